@@ -36,14 +36,34 @@ func NewAccessList() *AccessList {
 }
 
 func (list *AccessList) AccessPath(path string) {
+	list.AccessPathAt(path, time.Now().Unix())
+}
+
+// Record an access for path at the given unix time
+func (list *AccessList) AccessPathAt(path string, at int64) {
 	list.mutex.Lock()
 	defer list.mutex.Unlock()
 	// remove first so we can re-order correctly with new time
 	list.ordered.Remove(path)
-	list.pathTimes[path] = time.Now().Unix()
+	list.pathTimes[path] = at
 	list.ordered.Add(path)
 }
 
+// Returns a snapshot of all tracked paths, least recently accessed first
+func (list *AccessList) OldestPaths() []string {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+
+	paths := make([]string, 0, list.ordered.Len())
+	iter := list.ordered.Iterator()
+
+	for iter.Next() {
+		paths = append(paths, iter.Key().(string))
+	}
+
+	return paths
+}
+
 func (list *AccessList) RemovePath(path string) {
 	list.mutex.Lock()
 	defer list.mutex.Unlock()
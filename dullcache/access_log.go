@@ -0,0 +1,181 @@
+package dullcache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	accessLogJSON     = "json"
+	accessLogCombined = "combined"
+)
+
+type contextKey int
+
+const accessRecordKey contextKey = 0
+
+// A single request written to the access log
+type accessRecord struct {
+	Time        time.Time `json:"time"`
+	RemoteAddr  string    `json:"remote_addr"`
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	Protocol    string    `json:"protocol"`
+	Status      int       `json:"status"`
+	BytesSent   int64     `json:"bytes_sent"`
+	CacheStatus string    `json:"cache_status,omitempty"`
+	OriginTTFB  float64   `json:"origin_ttfb,omitempty"`
+	Duration    float64   `json:"duration"`
+	Aborted     bool      `json:"aborted"`
+	Referer     string    `json:"referer,omitempty"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+}
+
+// Writes one record per request to a file that can be reopened for rotation
+type accessLogger struct {
+	fname  string
+	format string
+
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func newAccessLogger(fname, format string) (*accessLogger, error) {
+	if format != accessLogJSON && format != accessLogCombined {
+		return nil, fmt.Errorf("unknown access log format: %v", format)
+	}
+
+	logger := &accessLogger{
+		fname:  fname,
+		format: format,
+	}
+
+	err := logger.Reopen()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return logger, nil
+}
+
+// Opens the log file again, closing the previous one. Called after the file
+// has been moved away by log rotation
+func (logger *accessLogger) Reopen() error {
+	file, err := os.OpenFile(logger.fname,
+		os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+
+	if logger.file != nil {
+		logger.file.Close()
+	}
+
+	logger.file = file
+	return nil
+}
+
+func (logger *accessLogger) Log(record *accessRecord) error {
+	var line []byte
+
+	switch logger.format {
+	case accessLogJSON:
+		out, err := json.Marshal(record)
+
+		if err != nil {
+			return err
+		}
+
+		line = append(out, '\n')
+	default:
+		line = []byte(formatCombined(record))
+	}
+
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+
+	_, err := logger.file.Write(line)
+	return err
+}
+
+func (logger *accessLogger) Close() error {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	return logger.file.Close()
+}
+
+// Combined log format with the cache fields appended
+func formatCombined(record *accessRecord) string {
+	cacheStatus := record.CacheStatus
+	if cacheStatus == "" {
+		cacheStatus = "-"
+	}
+
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %d %q %q cache=%s ttfb=%.3f duration=%.3f aborted=%v\n",
+		record.RemoteAddr,
+		record.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		record.Method,
+		record.Path,
+		record.Protocol,
+		record.Status,
+		record.BytesSent,
+		record.Referer,
+		record.UserAgent,
+		cacheStatus,
+		record.OriginTTFB,
+		record.Duration,
+		record.Aborted)
+}
+
+// Returns the access record for the request, nil if access logging is off
+func requestAccessRecord(r *http.Request) *accessRecord {
+	record, _ := r.Context().Value(accessRecordKey).(*accessRecord)
+	return record
+}
+
+// Wraps a handler to write an access log record for every request
+func accessLogHandler(logger *accessLogger, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		record := &accessRecord{
+			Time:       time.Now(),
+			RemoteAddr: r.RemoteAddr,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Protocol:   r.Proto,
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+		}
+
+		counter := &countingWriter{ResponseWriter: w}
+		handler.ServeHTTP(counter,
+			r.WithContext(context.WithValue(ctx, accessRecordKey, record)))
+
+		record.Status = counter.status
+		if record.Status == 0 {
+			record.Status = http.StatusOK
+		}
+
+		record.BytesSent = counter.written
+		record.CacheStatus = w.Header().Get(cacheStatusHeader)
+		record.Duration = time.Since(record.Time).Seconds()
+		record.Aborted = counter.err != nil || ctx.Err() != nil
+
+		err := logger.Log(record)
+
+		if err != nil {
+			log.Print("Warning: failed to write access log: ", err)
+		}
+	})
+}
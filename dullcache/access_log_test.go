@@ -0,0 +1,97 @@
+package dullcache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLogJSON(t *testing.T) {
+	server, cleanup := setupTestServer(t, map[string]string{
+		"/bucket/file.txt": "0123456789",
+	})
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "dullcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fname := path.Join(dir, "access.log")
+	logger, err := newAccessLogger(fname, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := accessLogHandler(logger, errorHandler(server.cacheHandler))
+
+	req, _ := http.NewRequest("GET", "/bucket/file.txt", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// rotate the log away and make sure new records go to a new file
+	os.Rename(fname, fname+".1")
+
+	if err := logger.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	logger.Close()
+
+	for _, name := range []string{fname + ".1", fname} {
+		out, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var record accessRecord
+		if err := json.Unmarshal(out, &record); err != nil {
+			t.Fatal(err)
+		}
+
+		if record.RemoteAddr != "10.0.0.1:1234" || record.Method != "GET" ||
+			record.Path != "/bucket/file.txt" || record.Status != 200 ||
+			record.BytesSent != 10 || record.CacheStatus != "HIT" || record.Aborted {
+			t.Error("unexpected access record", string(out))
+		}
+	}
+}
+
+func TestFormatCombined(t *testing.T) {
+	line := formatCombined(&accessRecord{
+		Time:        time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC),
+		RemoteAddr:  "10.0.0.1:1234",
+		Method:      "GET",
+		Path:        "/bucket/file.txt",
+		Protocol:    "HTTP/1.1",
+		Status:      200,
+		BytesSent:   10,
+		CacheStatus: "MISS",
+		OriginTTFB:  0.25,
+		Duration:    1.5,
+		UserAgent:   "curl",
+	})
+
+	expected := `10.0.0.1:1234 - - [02/Jan/2016:03:04:05 +0000] "GET /bucket/file.txt HTTP/1.1" 200 10 "" "curl" cache=MISS ttfb=0.250 duration=1.500 aborted=false`
+
+	if strings.TrimSpace(line) != expected {
+		t.Error("unexpected combined line", line)
+	}
+}
+
+func TestUnknownAccessLogFormat(t *testing.T) {
+	_, err := newAccessLogger("access.log", "xml")
+
+	if err == nil {
+		t.Error("expected unknown format to fail")
+	}
+}
@@ -0,0 +1,297 @@
+package dullcache
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type adminRole int
+
+const (
+	adminRoleNone adminRole = iota
+	// can view stats and list paths
+	adminRoleRead
+	// can also delete and purge paths
+	adminRoleWrite
+)
+
+const (
+	adminSignatureScheme    = "DULLCACHE-HMAC"
+	adminTimestampHeader    = "X-Dullcache-Timestamp"
+	adminSignatureMaxSkew   = time.Duration(5) * time.Minute
+	adminRoleNameRead       = "read"
+	adminRoleNameWrite      = "write"
+	adminBearerScheme       = "Bearer"
+	adminForwardedForHeader = "X-Forwarded-For"
+)
+
+// A credential for admin requests. The secret can be sent directly as a bearer
+// token, or used to sign requests with HMAC-SHA256:
+//
+//	Authorization: DULLCACHE-HMAC <ID>:<hex signature>
+//	X-Dullcache-Timestamp: <unix time>
+//
+// where the signature is of "<method>\n<request uri>\n<timestamp>"
+type AdminKey struct {
+	ID     string
+	Secret string
+	Role   string
+}
+
+// set on requests that came in over the admin unix socket
+const adminSocketKey contextKey = 1
+
+type adminAuth struct {
+	writeNets      []*net.IPNet
+	readNets       []*net.IPNet
+	trustedProxies []*net.IPNet
+	keys           map[string]AdminKey
+	keyRoles       map[string]adminRole
+}
+
+func parseAdminRole(name string) (adminRole, error) {
+	switch name {
+	case adminRoleNameRead:
+		return adminRoleRead, nil
+	case adminRoleNameWrite:
+		return adminRoleWrite, nil
+	}
+
+	return adminRoleNone, fmt.Errorf("unknown admin role: %v", name)
+}
+
+// Parses a list of IP addresses and CIDR ranges. Addresses may be wrapped in
+// brackets
+func parseIPNets(addresses []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+
+	for _, address := range addresses {
+		address = strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+
+		if strings.Contains(address, "/") {
+			_, ipNet, err := net.ParseCIDR(address)
+
+			if err != nil {
+				return nil, err
+			}
+
+			nets = append(nets, ipNet)
+			continue
+		}
+
+		ip := net.ParseIP(address)
+
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address: %v", address)
+		}
+
+		bits := 8 * net.IPv4len
+		if ip.To4() == nil {
+			bits = 8 * net.IPv6len
+		}
+
+		nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+
+	return nets, nil
+}
+
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newAdminAuth(config *Config) (*adminAuth, error) {
+	writeNets, err := parseIPNets(config.AdminAddresses)
+
+	if err != nil {
+		return nil, err
+	}
+
+	readNets, err := parseIPNets(config.AdminReadAddresses)
+
+	if err != nil {
+		return nil, err
+	}
+
+	trustedProxies, err := parseIPNets(config.TrustedProxies)
+
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &adminAuth{
+		writeNets:      writeNets,
+		readNets:       readNets,
+		trustedProxies: trustedProxies,
+		keys:           make(map[string]AdminKey),
+		keyRoles:       make(map[string]adminRole),
+	}
+
+	for _, key := range config.AdminKeys {
+		if key.ID == "" || key.Secret == "" {
+			return nil, fmt.Errorf("admin keys need an ID and Secret")
+		}
+
+		role, err := parseAdminRole(key.Role)
+
+		if err != nil {
+			return nil, err
+		}
+
+		auth.keys[key.ID] = key
+		auth.keyRoles[key.ID] = role
+	}
+
+	return auth, nil
+}
+
+// Returns the address of the client. When the connection comes from a trusted
+// proxy the closest untrusted address in X-Forwarded-For is used
+func (auth *adminAuth) clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return nil
+	}
+
+	ip := net.ParseIP(host)
+
+	if !ipInNets(ip, auth.trustedProxies) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header[adminForwardedForHeader], ","), ",")
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		forwardedIP := net.ParseIP(strings.TrimSpace(forwarded[i]))
+
+		if forwardedIP == nil {
+			return nil
+		}
+
+		ip = forwardedIP
+
+		if !ipInNets(ip, auth.trustedProxies) {
+			break
+		}
+	}
+
+	return ip
+}
+
+func adminSignature(secret, method, requestURI, timestamp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s", method, requestURI, timestamp)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Returns the role granted by the Authorization header
+func (auth *adminAuth) keyRole(r *http.Request) adminRole {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+
+	if len(parts) != 2 {
+		return adminRoleNone
+	}
+
+	scheme, credentials := parts[0], parts[1]
+
+	switch scheme {
+	case adminBearerScheme:
+		for id, key := range auth.keys {
+			if subtle.ConstantTimeCompare([]byte(key.Secret), []byte(credentials)) == 1 {
+				return auth.keyRoles[id]
+			}
+		}
+	case adminSignatureScheme:
+		parts := strings.SplitN(credentials, ":", 2)
+
+		if len(parts) != 2 {
+			return adminRoleNone
+		}
+
+		id, signature := parts[0], parts[1]
+		key, found := auth.keys[id]
+
+		if !found {
+			return adminRoleNone
+		}
+
+		timestamp := r.Header.Get(adminTimestampHeader)
+		signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+
+		if err != nil {
+			return adminRoleNone
+		}
+
+		skew := time.Since(time.Unix(signedAt, 0))
+		if skew > adminSignatureMaxSkew || skew < -adminSignatureMaxSkew {
+			return adminRoleNone
+		}
+
+		expected := adminSignature(key.Secret, r.Method, r.RequestURI, timestamp)
+
+		if hmac.Equal([]byte(expected), []byte(signature)) {
+			return auth.keyRoles[id]
+		}
+	}
+
+	return adminRoleNone
+}
+
+// Marks requests as coming from the admin unix socket. Access to the socket is
+// controlled by its file permissions so these requests get the write role
+func adminSocketHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w,
+			r.WithContext(context.WithValue(r.Context(), adminSocketKey, true)))
+	})
+}
+
+// Returns the highest role the request is granted
+func (auth *adminAuth) requestRole(r *http.Request) adminRole {
+	if fromSocket, _ := r.Context().Value(adminSocketKey).(bool); fromSocket {
+		return adminRoleWrite
+	}
+
+	role := auth.keyRole(r)
+
+	if role == adminRoleWrite {
+		return role
+	}
+
+	ip := auth.clientIP(r)
+
+	if ipInNets(ip, auth.writeNets) {
+		return adminRoleWrite
+	}
+
+	if ipInNets(ip, auth.readNets) {
+		return adminRoleRead
+	}
+
+	return role
+}
+
+// Checks if the request is allowed to do something requiring role
+func (auth *adminAuth) authorize(r *http.Request, role adminRole) bool {
+	return auth.requestRole(r) >= role
+}
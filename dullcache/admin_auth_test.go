@@ -0,0 +1,203 @@
+package dullcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func getAdminAuth(t *testing.T) *adminAuth {
+	auth, err := newAdminAuth(&Config{
+		AdminAddresses:     []string{"127.0.0.1", "[::1]", "10.1.0.0/16"},
+		AdminReadAddresses: []string{"192.168.0.0/24"},
+		TrustedProxies:     []string{"10.9.9.9"},
+		AdminKeys: []AdminKey{
+			{ID: "deploy", Secret: "deploy-secret", Role: "write"},
+			{ID: "monitor", Secret: "monitor-secret", Role: "read"},
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return auth
+}
+
+func adminRequest(remoteAddr string) *http.Request {
+	req, _ := http.NewRequest("DELETE", "/admin/delete-path?path=/hello", nil)
+	req.RequestURI = "/admin/delete-path?path=/hello"
+	req.RemoteAddr = remoteAddr
+	return req
+}
+
+func TestAdminAuthAddresses(t *testing.T) {
+	auth := getAdminAuth(t)
+
+	cases := map[string]adminRole{
+		"127.0.0.1:1234":   adminRoleWrite,
+		"[::1]:1234":       adminRoleWrite,
+		"10.1.20.3:1234":   adminRoleWrite,
+		"192.168.0.9:1234": adminRoleRead,
+		"8.8.8.8:1234":     adminRoleNone,
+		"10.2.0.1:1234":    adminRoleNone,
+	}
+
+	for remoteAddr, expected := range cases {
+		if role := auth.requestRole(adminRequest(remoteAddr)); role != expected {
+			t.Error("expected", remoteAddr, "to have role", expected, "got", role)
+		}
+	}
+}
+
+func TestAdminAuthTrustedProxy(t *testing.T) {
+	auth := getAdminAuth(t)
+
+	req := adminRequest("10.9.9.9:1234")
+	req.Header.Set("X-Forwarded-For", "8.8.8.8, 192.168.0.9")
+
+	if role := auth.requestRole(req); role != adminRoleRead {
+		t.Error("expected forwarded client to have read role, got", role)
+	}
+
+	// forwarded header is ignored from untrusted addresses
+	req = adminRequest("8.8.8.8:1234")
+	req.Header.Set("X-Forwarded-For", "127.0.0.1")
+
+	if role := auth.requestRole(req); role != adminRoleNone {
+		t.Error("expected untrusted forwarded header to be ignored, got", role)
+	}
+}
+
+func TestAdminAuthKeys(t *testing.T) {
+	auth := getAdminAuth(t)
+
+	req := adminRequest("8.8.8.8:1234")
+	req.Header.Set("Authorization", "Bearer monitor-secret")
+
+	if role := auth.requestRole(req); role != adminRoleRead {
+		t.Error("expected bearer token to have read role, got", role)
+	}
+
+	req.Header.Set("Authorization", "Bearer wrong")
+
+	if role := auth.requestRole(req); role != adminRoleNone {
+		t.Error("expected wrong bearer token to have no role, got", role)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := adminSignature("deploy-secret", "DELETE",
+		"/admin/delete-path?path=/hello", timestamp)
+
+	req.Header.Set("Authorization", "DULLCACHE-HMAC deploy:"+signature)
+	req.Header.Set("X-Dullcache-Timestamp", timestamp)
+
+	if role := auth.requestRole(req); role != adminRoleWrite {
+		t.Error("expected signed request to have write role, got", role)
+	}
+
+	req.RequestURI = "/admin/delete-path?path=/other"
+
+	if role := auth.requestRole(req); role != adminRoleNone {
+		t.Error("expected signature for another uri to have no role, got", role)
+	}
+
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	req = adminRequest("8.8.8.8:1234")
+	req.Header.Set("Authorization", "DULLCACHE-HMAC deploy:"+adminSignature(
+		"deploy-secret", "DELETE", "/admin/delete-path?path=/hello", old))
+	req.Header.Set("X-Dullcache-Timestamp", old)
+
+	if role := auth.requestRole(req); role != adminRoleNone {
+		t.Error("expected old signature to have no role, got", role)
+	}
+}
+
+func TestAdminAuthInvalidConfig(t *testing.T) {
+	if _, err := newAdminAuth(&Config{AdminAddresses: []string{"nope"}}); err == nil {
+		t.Error("expected invalid address to fail")
+	}
+
+	if _, err := newAdminAuth(&Config{AdminKeys: []AdminKey{
+		{ID: "a", Secret: "b", Role: "root"},
+	}}); err == nil {
+		t.Error("expected unknown role to fail")
+	}
+}
+
+func TestAdminHandlerRoles(t *testing.T) {
+	server, cleanup := setupTestServer(t, nil)
+	defer cleanup()
+
+	server.adminAuth = getAdminAuth(t)
+	called := false
+
+	handler := server.admin(adminRoleWrite, func(w http.ResponseWriter, r *http.Request) error {
+		called = true
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, adminRequest("192.168.0.9:1234"))
+
+	if w.Code != http.StatusForbidden || called {
+		t.Error("expected read role to be rejected from write handler")
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, adminRequest("127.0.0.1:1234"))
+
+	if w.Code != http.StatusOK || !called {
+		t.Error("expected write role to be allowed, got", w.Code)
+	}
+
+	// purges through the cache handler need the write role too
+	req, _ := http.NewRequest("DELETE", "/bucket/file.txt", nil)
+	req.RemoteAddr = "192.168.0.9:1234"
+
+	w = httptest.NewRecorder()
+	errorHandler(server.cacheHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden || server.fileCache.PathNeedsPurge("/bucket/file.txt") {
+		t.Error("expected purge from read role to be rejected, got", w.Code)
+	}
+
+	// stats need the read role
+	cases := map[string]int{
+		"127.0.0.1:1234":   http.StatusOK,
+		"192.168.0.9:1234": http.StatusOK,
+		"8.8.8.8:1234":     http.StatusForbidden,
+	}
+
+	for _, path := range []string{"/stat", "/stat/active", "/metrics"} {
+		for remoteAddr, expected := range cases {
+			req, _ := http.NewRequest("GET", path, nil)
+			req.RemoteAddr = remoteAddr
+
+			w = httptest.NewRecorder()
+			server.AdminHandler().ServeHTTP(w, req)
+
+			if w.Code != expected {
+				t.Error("expected", expected, "for", path, "from", remoteAddr, "got", w.Code)
+			}
+		}
+	}
+}
+
+func TestAdminSocketRole(t *testing.T) {
+	auth := getAdminAuth(t)
+	var role adminRole
+
+	handler := adminSocketHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role = auth.requestRole(r)
+	}))
+
+	req := adminRequest("")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if role != adminRoleWrite {
+		t.Error("expected socket request to have write role, got", role)
+	}
+}
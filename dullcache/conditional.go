@@ -0,0 +1,66 @@
+package dullcache
+
+import (
+	"net/http"
+	"strings"
+)
+
+// headers sent with a 304 response to a client
+var headersNotModified = []string{"Cache-Control", "Content-Location", "Date",
+	"Etag", "Expires", "Last-Modified", "Vary"}
+
+// Checks if two entity tags match using weak comparison
+func etagsMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// Checks the client's If-None-Match and If-Modified-Since against the stored
+// headers of a path. If-Modified-Since is ignored when If-None-Match is sent
+func requestNotModified(r *http.Request, headers http.Header) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := headers.Get("Etag")
+
+		if etag == "" {
+			return false
+		}
+
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+
+			if candidate == "*" || etagsMatch(candidate, etag) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	ifModifiedSince, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+
+	if err != nil {
+		return false
+	}
+
+	lastModified, err := http.ParseTime(headers.Get("Last-Modified"))
+
+	if err != nil {
+		return false
+	}
+
+	return !lastModified.After(ifModifiedSince)
+}
+
+// Responds with 304 Not Modified using the stored headers of a path
+func writeNotModified(w http.ResponseWriter, headers http.Header) {
+	for _, k := range headersNotModified {
+		if v, found := headers[k]; found {
+			w.Header()[k] = v
+		}
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+}
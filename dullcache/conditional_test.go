@@ -0,0 +1,58 @@
+package dullcache
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRequestNotModified(t *testing.T) {
+	headers := http.Header{
+		"Etag":          {`"abc"`},
+		"Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"},
+	}
+
+	for _, test := range []struct {
+		method      string
+		header      string
+		value       string
+		notModified bool
+	}{
+		{"GET", "", "", false},
+		{"GET", "If-None-Match", `"abc"`, true},
+		{"HEAD", "If-None-Match", `"abc"`, true},
+		{"POST", "If-None-Match", `"abc"`, false},
+		{"GET", "If-None-Match", `W/"abc"`, true},
+		{"GET", "If-None-Match", `"xyz", "abc"`, true},
+		{"GET", "If-None-Match", "*", true},
+		{"GET", "If-None-Match", `"xyz"`, false},
+		{"GET", "If-Modified-Since", "Mon, 02 Jan 2006 15:04:05 GMT", true},
+		{"GET", "If-Modified-Since", "Tue, 03 Jan 2006 15:04:05 GMT", true},
+		{"GET", "If-Modified-Since", "Sun, 01 Jan 2006 15:04:05 GMT", false},
+		{"GET", "If-Modified-Since", "not a date", false},
+	} {
+		req, _ := http.NewRequest(test.method, "/bucket/file.txt", nil)
+
+		if test.header != "" {
+			req.Header.Set(test.header, test.value)
+		}
+
+		if requestNotModified(req, headers) != test.notModified {
+			t.Error("unexpected result for", test.method, test.header, test.value)
+		}
+	}
+
+	// a matching date doesn't count when the etag differs
+	req, _ := http.NewRequest("GET", "/bucket/file.txt", nil)
+	req.Header.Set("If-None-Match", `"xyz"`)
+	req.Header.Set("If-Modified-Since", "Tue, 03 Jan 2006 15:04:05 GMT")
+
+	if requestNotModified(req, headers) {
+		t.Error("expected If-None-Match to take precedence")
+	}
+
+	req.Header.Del("If-None-Match")
+
+	if requestNotModified(req, http.Header{}) {
+		t.Error("expected no match without stored validators")
+	}
+}
@@ -15,15 +15,112 @@ type Config struct {
 	GoogleAccessID              string
 	GoogleStoragePrivateKeyPath string
 	BaseURL                     string
+
+	// Equivalent origins tried in order when BaseURL fails to respond or
+	// responds with a server error. Origins can be local directories given as
+	// file:///path URLs
+	FallbackURLs []string
+
+	// Seconds between HEAD requests to HealthCheckPath on the origins of routes
+	// with fallbacks. Unhealthy origins are skipped until they respond again,
+	// though requests still retry them 30 seconds after they last failed. 0
+	// disables the checks
+	HealthCheckInterval int64
+	HealthCheckPath     string
+
+	// Origins for requests matching a Host and/or path prefix, checked in
+	// order. Requests matching no route go to BaseURL. Paths of a route are
+	// cached, listed and purged as Name:/path
+	Routes []RouteConfig
+
+	// Serve the stat and admin routes on this address instead of Address, use
+	// unix:/path/to/socket to listen on a unix socket
+	AdminAddress string
+
+	// AdminAddresses are addresses or CIDR ranges with full admin access, read
+	// addresses can only view stats and lists. Requests from trusted proxies are
+	// checked against the client address in X-Forwarded-For
+	AdminReadAddresses []string
+	TrustedProxies     []string
+
+	// Credentials for admin requests sent as bearer tokens or HMAC signatures
+	AdminKeys []AdminKey
+
+	// Buckets that dullcache signs origin GETs for with the configured key, so
+	// private buckets can be cached. Clients can be required to send URLs signed
+	// by the same key, otherwise unsigned URLs are served
+	SignedOriginBuckets     []string
+	RequireClientSignatures bool
+
+	// Sign every request to the origin with AWS Signature Version 4, for S3
+	// compatible stores like MinIO or Ceph RGW. Takes precedence over the Google
+	// credentials for signing, the region defaults to us-east-1
+	AWSAccessKeyID     string
+	AWSSecretAccessKey string
+	AWSSessionToken    string
+	AWSRegion          string
+
+	// Evict least recently accessed files once the tracked size of the cache
+	// goes over this many bytes, 0 disables eviction
+	MaxCacheBytes int64
+
+	// Keep fetching a file into the cache after the client that requested it
+	// disconnects, as long as no more than AbandonedFillMaxBytes remain. A max
+	// of 0 has no limit
+	FinishAbandonedFills  bool
+	AbandonedFillMaxBytes int64
+
+	// Seconds paths starting with each prefix stay fresh when the backend sends
+	// no Cache-Control or Expires headers. The longest matching prefix is used,
+	// paths without one are served until purged. Responses marked private, like
+	// GCS objects that aren't public, only count s-maxage as a lifetime
+	DefaultTTLs map[string]int64
+
+	// Seconds past expiry a stale path is served right away while it's
+	// refreshed in the background, and served when the backend fails or can't
+	// be reached. The stale-while-revalidate and stale-if-error Cache-Control
+	// directives from the backend take precedence. Files in the cache dir that
+	// aren't verified with the backend yet are served while either is enabled
+	StaleWhileRevalidate int64
+	StaleIfError         int64
+
+	// Backend response header with the surrogate keys a path is tagged with,
+	// separated by spaces or commas. Paths can be purged or deleted by tag
+	SurrogateKeyHeader string
+
+	// Remember backend responses with these statuses for NegativeCacheTTL
+	// seconds so requests for missing paths don't all reach the backend. A TTL
+	// of 0 disables negative caching. DELETE purges remembered responses
+	NegativeCacheStatuses []int
+	NegativeCacheTTL      int64
+
+	// Write a record for every request to this file in the given format, json
+	// or combined. The file is reopened on SIGUSR1
+	AccessLogPath   string
+	AccessLogFormat string
 }
 
 var defaultConfig = Config{
 	Address:                     ":9192",
+	AdminAddress:                "",
 	CacheDir:                    "cache",
 	AdminAddresses:              []string{"127.0.0.1", "[::1]"},
 	GoogleAccessID:              "",
 	GoogleStoragePrivateKeyPath: "",
 	BaseURL:                     "http://commondatastorage.googleapis.com",
+	HealthCheckInterval:         30,
+	HealthCheckPath:             "/",
+	RequireClientSignatures:     false,
+	MaxCacheBytes:               0,
+	FinishAbandonedFills:        false,
+	AbandonedFillMaxBytes:       100 * 1024 * 1024,
+	StaleWhileRevalidate:        0,
+	StaleIfError:                0,
+	SurrogateKeyHeader:          "X-Goog-Meta-Surrogate-Key",
+	NegativeCacheStatuses:       []int{404, 410},
+	NegativeCacheTTL:            0,
+	AccessLogPath:               "",
+	AccessLogFormat:             "combined",
 }
 
 func LoadConfig(fname string) *Config {
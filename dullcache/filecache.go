@@ -2,24 +2,53 @@ package dullcache
 
 import (
 	"fmt"
+	"io/ioutil"
+	"log"
 	"net/http"
 	"os"
 	"path"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	b58 "github.com/jbenet/go-base58"
 )
 
+// Metadata tracked for every path that is available in the cache
+type CacheEntry struct {
+	Headers   http.Header
+	FetchedAt time.Time
+	// when the entry has to be revalidated, zero if never
+	ExpiresAt time.Time
+	// surrogate keys from the tag header
+	Tags []string
+}
+
+// Prefix for temporary files written into the cache dir. Cache files are
+// base58 encoded so they never start with it
+const tempFilePrefix = ".tmp-"
+
+// Temporary files untouched for this long are considered abandoned
+const tempFileMaxAge = time.Duration(10) * time.Minute
+
 type FileCache struct {
 	basePath       string
 	busyMutex      sync.RWMutex
 	busyPaths      map[string]bool
 	availableMutex sync.RWMutex
-	availablePaths map[string]http.Header
+	availablePaths map[string]*CacheEntry
 	purgedMutex    sync.RWMutex
 	purgedPaths    map[string]bool
+	missingMutex   sync.RWMutex
+	missingPaths   map[string]*NegativeEntry
+	fillsMutex     sync.RWMutex
+	fills          map[string]*cacheFill
+	defaultTTLs    map[string]time.Duration
+	tagHeader      string
+	tagPaths       map[string]map[string]bool
 	accessList     *AccessList
 }
 
@@ -28,8 +57,12 @@ func NewFileCache(basePath string) *FileCache {
 		basePath:       basePath,
 		accessList:     NewAccessList(),
 		busyPaths:      make(map[string]bool),
-		availablePaths: make(map[string]http.Header),
+		availablePaths: make(map[string]*CacheEntry),
 		purgedPaths:    make(map[string]bool),
+		missingPaths:   make(map[string]*NegativeEntry),
+		fills:          make(map[string]*cacheFill),
+		defaultTTLs:    make(map[string]time.Duration),
+		tagPaths:       make(map[string]map[string]bool),
 	}
 }
 
@@ -68,6 +101,17 @@ func (cache *FileCache) CacheFilePath(subPath string) (string, error) {
 
 // Checks if a path is available for being served to client
 func (cache *FileCache) PathAvailable(path string) http.Header {
+	entry := cache.PathEntry(path)
+
+	if entry == nil {
+		return nil
+	}
+
+	return entry.Headers
+}
+
+// Returns the cache entry for an available path, nil if it's not available
+func (cache *FileCache) PathEntry(path string) *CacheEntry {
 	cache.availableMutex.RLock()
 	defer cache.availableMutex.RUnlock()
 	return cache.availablePaths[path]
@@ -104,10 +148,32 @@ func (cache *FileCache) PathBusy(path string) bool {
 
 // Mark a path as being available to be served by the cache, takes the headers
 // from the backend request to fetch the file
-func (cache *FileCache) MarkPathAvailable(path string, headers http.Header) {
+func (cache *FileCache) MarkPathAvailable(path string, headers http.Header) *CacheEntry {
+	return cache.MarkPathAvailableAt(path, headers, time.Now())
+}
+
+// Mark a path as available with the time the file was fetched from the
+// backend. The entry stays fresh for as long as the headers allow, or the
+// default TTL for the path
+func (cache *FileCache) MarkPathAvailableAt(path string, headers http.Header, fetchedAt time.Time) *CacheEntry {
 	cache.availableMutex.Lock()
 	defer cache.availableMutex.Unlock()
-	cache.availablePaths[path] = headers
+
+	entry := &CacheEntry{
+		Headers:   headers,
+		FetchedAt: fetchedAt,
+		ExpiresAt: cache.pathExpiresAt(path, headers, fetchedAt),
+		Tags:      cache.headersTags(headers),
+	}
+
+	if previous := cache.availablePaths[path]; previous != nil {
+		cache.unindexTags(path, previous.Tags)
+	}
+
+	cache.availablePaths[path] = entry
+	cache.indexTags(path, entry.Tags)
+
+	return entry
 }
 
 // Marks a path as busy. Paths should be marked busy when any write disk
@@ -175,23 +241,102 @@ func (cache *FileCache) TrackedSize() int64 {
 
 	var total int64
 
-	for _, headers := range cache.availablePaths {
-		contentLenStr := headers.Get("Content-Length")
-		if contentLenStr != "" {
-			contentLen, err := strconv.Atoi(contentLenStr)
-			if err == nil {
-				total += int64(contentLen)
+	for _, entry := range cache.availablePaths {
+		total += headersContentLength(entry.Headers)
+	}
+
+	return total
+}
+
+// Evict the least recently accessed paths until the tracked size is at or
+// below targetSize. Busy paths are skipped. Returns the evicted paths and the
+// number of bytes they were tracked as
+func (cache *FileCache) EvictToSize(targetSize int64) ([]string, int64) {
+	overage := cache.TrackedSize() - targetSize
+
+	if overage <= 0 {
+		return nil, 0
+	}
+
+	var evicted []string
+	var evictedBytes int64
+
+	for _, path := range cache.accessList.OldestPaths() {
+		if evictedBytes >= overage {
+			break
+		}
+
+		if cache.PathBusy(path) {
+			continue
+		}
+
+		size := headersContentLength(cache.PathAvailable(path))
+		err := cache.DeletePath(path)
+
+		if err != nil {
+			log.Print("Failed to evict path: ", path, ": ", err)
+			if cache.PathAvailable(path) != nil {
+				continue
 			}
+		}
+
+		evicted = append(evicted, path)
+		evictedBytes += size
+	}
+
+	return evicted, evictedBytes
+}
+
+// Returns the paths that match, either available or stored in the cache dir
+// without being tracked. The paths are sorted
+func (cache *FileCache) MatchPaths(match func(string) bool) ([]string, error) {
+	matched := make(map[string]bool)
 
+	cache.availableMutex.RLock()
+	for path := range cache.availablePaths {
+		if match(path) {
+			matched[path] = true
 		}
 	}
+	cache.availableMutex.RUnlock()
 
-	return total
+	infos, err := ioutil.ReadDir(cache.basePath)
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, info := range infos {
+		fname := info.Name()
+
+		if info.IsDir() || fname == indexFname || strings.HasPrefix(fname, tempFilePrefix) {
+			continue
+		}
+
+		// cache files are named by the base58 encoded path
+		path := string(b58.Decode(fname))
+
+		if path == "" || b58.Encode([]byte(path)) != fname {
+			continue
+		}
+
+		if match(path) {
+			matched[path] = true
+		}
+	}
+
+	paths := make([]string, 0, len(matched))
+	for path := range matched {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+	return paths, nil
 }
 
 // Remove a path from the cache
 func (cache *FileCache) DeletePath(path string) error {
-	fname, err := fileCache.CacheFilePath(path)
+	fname, err := cache.CacheFilePath(path)
 
 	if err != nil {
 		return err
@@ -204,6 +349,11 @@ func (cache *FileCache) DeletePath(path string) error {
 	// remove from everything
 	cache.availableMutex.Lock()
 	defer cache.availableMutex.Unlock()
+
+	if entry := cache.availablePaths[path]; entry != nil {
+		cache.unindexTags(path, entry.Tags)
+	}
+
 	delete(cache.availablePaths, path)
 
 	cache.purgedMutex.Lock()
@@ -219,19 +369,114 @@ func (cache *FileCache) DeletePath(path string) error {
 	return syscall.Unlink(fname)
 }
 
+// Returns the Content-Length from the headers, 0 if missing or invalid
+func headersContentLength(headers http.Header) int64 {
+	contentLen, err := strconv.ParseInt(headers.Get("Content-Length"), 10, 64)
+
+	if err != nil {
+		return 0
+	}
+
+	return contentLen
+}
+
+// Creates a temporary file in the cache dir to fill subPath. The file is only
+// moved into place by CommitPathWriter so readers never see a partial file
 func (cache *FileCache) PathWriter(subPath string) (*os.File, error) {
-	cacheTarget, err := cache.CacheFilePath(subPath)
+	_, err := cache.CacheFilePath(subPath)
 
 	if err != nil {
 		return nil, err
 	}
 
-	err = os.MkdirAll(path.Dir(cacheTarget), 0755)
+	err = os.MkdirAll(cache.basePath, 0755)
 
 	if err != nil {
 		return nil, err
 	}
 
-	file, err := os.Create(cacheTarget)
-	return file, err
+	return ioutil.TempFile(cache.basePath, tempFilePrefix)
+}
+
+// Moves a file created by PathWriter into place for subPath. The file is
+// closed, and removed if the write can't be committed. A negative
+// expectedSize skips the size check
+func (cache *FileCache) CommitPathWriter(subPath string, file *os.File, expectedSize int64) error {
+	cacheTarget, err := cache.CacheFilePath(subPath)
+
+	if err != nil {
+		cache.AbortPathWriter(file)
+		return err
+	}
+
+	info, err := file.Stat()
+
+	if err != nil {
+		cache.AbortPathWriter(file)
+		return err
+	}
+
+	if expectedSize >= 0 && info.Size() != expectedSize {
+		cache.AbortPathWriter(file)
+		return fmt.Errorf("size mismatch, expected %v got %v",
+			expectedSize, info.Size())
+	}
+
+	err = file.Close()
+
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	err = os.Rename(file.Name(), cacheTarget)
+
+	if err != nil {
+		os.Remove(file.Name())
+	}
+
+	return err
+}
+
+// Closes and removes a file created by PathWriter without committing it
+func (cache *FileCache) AbortPathWriter(file *os.File) {
+	file.Close()
+	os.Remove(file.Name())
+}
+
+// Removes temporary files left in the cache dir by fills that never finished.
+// Files modified recently are kept since they may belong to the process being
+// replaced during a graceful restart. Returns the number of files removed
+func (cache *FileCache) RemoveTempFiles() (int, error) {
+	infos, err := ioutil.ReadDir(cache.basePath)
+
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+
+	for _, info := range infos {
+		if !strings.HasPrefix(info.Name(), tempFilePrefix) {
+			continue
+		}
+
+		if time.Since(info.ModTime()) < tempFileMaxAge {
+			continue
+		}
+
+		err := os.Remove(path.Join(cache.basePath, info.Name()))
+
+		if err != nil {
+			return removed, err
+		}
+
+		removed += 1
+	}
+
+	return removed, nil
 }
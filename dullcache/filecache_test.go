@@ -1,7 +1,10 @@
 package dullcache
 
 import (
+	"io/ioutil"
 	"net/http"
+	"os"
+	"strings"
 	"testing"
 )
 
@@ -51,3 +54,138 @@ func TestPathAvailable(t *testing.T) {
 		t.Fatal("expected to get available path")
 	}
 }
+
+func TestEvictToSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dullcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	cache := NewFileCache(dir)
+
+	for i, path := range []string{"/old.png", "/busy.png", "/new.png"} {
+		file, err := cache.PathWriter(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := cache.CommitPathWriter(path, file, -1); err != nil {
+			t.Fatal(err)
+		}
+
+		cache.MarkPathAvailable(path, http.Header{
+			"Content-Length": []string{"100"},
+		})
+
+		cache.accessList.AccessPathAt(path, int64(i))
+	}
+
+	cache.MarkPathBusy("/busy.png")
+
+	evicted, evictedBytes := cache.EvictToSize(150)
+
+	if len(evicted) != 2 || evicted[0] != "/old.png" || evicted[1] != "/new.png" {
+		t.Fatal("expected old and new paths to be evicted, got", evicted)
+	}
+
+	if evictedBytes != 200 {
+		t.Error("expected 200 bytes evicted, got", evictedBytes)
+	}
+
+	if cache.PathAvailable("/busy.png") == nil {
+		t.Error("expected busy path to be skipped")
+	}
+
+	if cache.TrackedSize() != 100 {
+		t.Error("expected tracked size to be 100, got", cache.TrackedSize())
+	}
+}
+
+func TestCommitPathWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dullcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	cache := NewFileCache(dir)
+
+	file, err := cache.PathWriter("/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.Write([]byte("hello"))
+
+	if size, _ := cache.PathMaybeAvailable("/hello.txt"); size != 0 {
+		t.Fatal("expected uncommitted file to not be visible")
+	}
+
+	if err := cache.CommitPathWriter("/hello.txt", file, 10); err == nil {
+		t.Fatal("expected size mismatch to fail commit")
+	}
+
+	file, err = cache.PathWriter("/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.Write([]byte("hello"))
+
+	if err := cache.CommitPathWriter("/hello.txt", file, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	if size, _ := cache.PathMaybeAvailable("/hello.txt"); size != 5 {
+		t.Fatal("expected committed file to have size 5, got", size)
+	}
+
+	infos, _ := ioutil.ReadDir(dir)
+	if len(infos) != 1 {
+		t.Error("expected only the committed file in cache dir, got", len(infos))
+	}
+}
+
+func TestMatchPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dullcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	cache := NewFileCache(dir)
+
+	for _, path := range []string{"/builds/1/a.zip", "/builds/2/a.zip", "/other.txt"} {
+		file, err := cache.PathWriter(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := cache.CommitPathWriter(path, file, -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// /builds/2/a.zip is only on disk, /builds/3/a.zip is only tracked
+	cache.MarkPathAvailable("/builds/1/a.zip", http.Header{})
+	cache.MarkPathAvailable("/builds/3/a.zip", http.Header{})
+	cache.SaveIndex()
+
+	paths, err := cache.MatchPaths(func(path string) bool {
+		return strings.HasPrefix(path, "/builds/")
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"/builds/1/a.zip", "/builds/2/a.zip", "/builds/3/a.zip"}
+
+	if strings.Join(paths, " ") != strings.Join(expected, " ") {
+		t.Error("unexpected matches", paths)
+	}
+}
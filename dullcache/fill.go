@@ -0,0 +1,182 @@
+package dullcache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// A file being written into the cache that concurrent requests for the same
+// path can stream from as bytes arrive
+type cacheFill struct {
+	file    *os.File
+	headers http.Header
+
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	written int64
+	done    bool
+	err     error
+	// readers attached to the fill that haven't been closed
+	readers int
+}
+
+// Reads a fill from the start, blocking until more bytes are written or the
+// fill finishes
+type fillReader struct {
+	fill   *cacheFill
+	file   *os.File
+	offset int64
+}
+
+func newCacheFill(file *os.File, headers http.Header) *cacheFill {
+	fill := &cacheFill{
+		file:    file,
+		headers: headers,
+	}
+
+	fill.cond = sync.NewCond(&fill.mutex)
+	return fill
+}
+
+func (fill *cacheFill) Write(p []byte) (int, error) {
+	n, err := fill.file.Write(p)
+
+	fill.mutex.Lock()
+	fill.written += int64(n)
+	fill.mutex.Unlock()
+	fill.cond.Broadcast()
+
+	return n, err
+}
+
+// Mark the fill as complete, err is set if the file will never be finished
+func (fill *cacheFill) finish(err error) {
+	fill.mutex.Lock()
+	fill.done = true
+	fill.err = err
+	fill.mutex.Unlock()
+	fill.cond.Broadcast()
+}
+
+// Blocks until more than offset bytes have been written or the fill is done.
+// Returns the total written so far, if the fill is done, and the error the
+// fill failed with
+func (fill *cacheFill) waitData(offset int64) (int64, bool, error) {
+	fill.mutex.Lock()
+	defer fill.mutex.Unlock()
+
+	for fill.written <= offset && !fill.done {
+		fill.cond.Wait()
+	}
+
+	return fill.written, fill.done, fill.err
+}
+
+// Stops the fill from taking new readers if nothing is reading it, so it can be
+// abandoned. Returns false if readers are attached and the fill has to finish
+func (fill *cacheFill) abandon(err error) bool {
+	fill.mutex.Lock()
+
+	if fill.readers > 0 {
+		fill.mutex.Unlock()
+		return false
+	}
+
+	fill.done = true
+	fill.err = err
+	fill.mutex.Unlock()
+	fill.cond.Broadcast()
+
+	return true
+}
+
+// Opens a new reader on the file being filled. Fails if the fill has already
+// failed or been abandoned
+func (fill *cacheFill) newReader() (*fillReader, error) {
+	fill.mutex.Lock()
+	defer fill.mutex.Unlock()
+
+	if fill.done && fill.err != nil {
+		return nil, fmt.Errorf("cache fill failed: %v", fill.err)
+	}
+
+	file, err := os.Open(fill.file.Name())
+
+	if err != nil {
+		return nil, err
+	}
+
+	fill.readers += 1
+
+	return &fillReader{
+		fill: fill,
+		file: file,
+	}, nil
+}
+
+func (reader *fillReader) Read(p []byte) (int, error) {
+	written, _, err := reader.fill.waitData(reader.offset)
+	available := written - reader.offset
+
+	if available <= 0 {
+		if err != nil {
+			return 0, fmt.Errorf("cache fill failed: %v", err)
+		}
+
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > available {
+		p = p[:available]
+	}
+
+	n, err := reader.file.ReadAt(p, reader.offset)
+	reader.offset += int64(n)
+
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (reader *fillReader) Close() error {
+	reader.fill.mutex.Lock()
+	reader.fill.readers -= 1
+	reader.fill.mutex.Unlock()
+
+	return reader.file.Close()
+}
+
+// Register a fill in progress for path so other requests can attach to it
+func (cache *FileCache) startFill(path string, file *os.File, headers http.Header) *cacheFill {
+	fill := newCacheFill(file, headers)
+
+	cache.fillsMutex.Lock()
+	defer cache.fillsMutex.Unlock()
+	cache.fills[path] = fill
+
+	return fill
+}
+
+// Unregister the fill for path and wake up everything reading from it
+func (cache *FileCache) endFill(path string, err error) {
+	cache.fillsMutex.Lock()
+	fill := cache.fills[path]
+	delete(cache.fills, path)
+	cache.fillsMutex.Unlock()
+
+	if fill != nil {
+		fill.finish(err)
+	}
+}
+
+// Returns the fill in progress for path, nil if there isn't one
+func (cache *FileCache) pathFill(path string) *cacheFill {
+	cache.fillsMutex.RLock()
+	defer cache.fillsMutex.RUnlock()
+	return cache.fills[path]
+}
@@ -0,0 +1,187 @@
+package dullcache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parses a Cache-Control header into lowercased directives and their values
+func parseCacheControl(header string) map[string]string {
+	directives := make(map[string]string)
+
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+
+		if part == "" {
+			continue
+		}
+
+		pair := strings.SplitN(part, "=", 2)
+		name := strings.ToLower(strings.TrimSpace(pair[0]))
+		value := ""
+
+		if len(pair) == 2 {
+			value = strings.Trim(strings.TrimSpace(pair[1]), `"`)
+		}
+
+		directives[name] = value
+	}
+
+	return directives
+}
+
+// Returns how long a response with headers stays fresh in a shared cache, and
+// false if the headers don't say. The age the response already had when it
+// left the backend is subtracted.
+//
+// dullcache controls access to what it caches, so private responses are cached
+// like any other. Their max-age and Expires are meant for the client and are
+// ignored, only s-maxage applies. GCS sends private, max-age=0 for every object
+// that isn't public
+func headersFreshness(headers http.Header, fetchedAt time.Time) (time.Duration, bool) {
+	directives := parseCacheControl(strings.Join(headers["Cache-Control"], ","))
+
+	for _, name := range []string{"no-store", "no-cache"} {
+		if _, found := directives[name]; found {
+			return 0, true
+		}
+	}
+
+	_, private := directives["private"]
+	lifetimeNames := []string{"s-maxage", "max-age"}
+
+	if private {
+		lifetimeNames = []string{"s-maxage"}
+	}
+
+	var lifetime time.Duration
+	found := false
+
+	for _, name := range lifetimeNames {
+		value, ok := directives[name]
+
+		if !ok {
+			continue
+		}
+
+		seconds, err := strconv.ParseInt(value, 10, 64)
+
+		if err != nil || seconds < 0 {
+			seconds = 0
+		}
+
+		lifetime = time.Duration(seconds) * time.Second
+		found = true
+		break
+	}
+
+	if !found && !private && headers.Get("Expires") != "" {
+		// invalid dates like 0 mean already expired
+		expires, _ := http.ParseTime(headers.Get("Expires"))
+		date, err := http.ParseTime(headers.Get("Date"))
+
+		if err != nil {
+			date = fetchedAt
+		}
+
+		lifetime = expires.Sub(date)
+		found = true
+	}
+
+	if !found {
+		return 0, false
+	}
+
+	age, err := strconv.ParseInt(headers.Get("Age"), 10, 64)
+
+	if err == nil && age > 0 {
+		lifetime -= time.Duration(age) * time.Second
+	}
+
+	if lifetime < 0 {
+		lifetime = 0
+	}
+
+	return lifetime, true
+}
+
+// Checks if the entry has to be revalidated with the backend before being
+// served. Entries without an expiration stay fresh
+func (entry *CacheEntry) Stale(now time.Time) bool {
+	return !entry.ExpiresAt.IsZero() && !now.Before(entry.ExpiresAt)
+}
+
+// Sets the TTL for paths starting with prefix when the backend doesn't send
+// any freshness headers. The longest matching prefix is used
+func (cache *FileCache) SetDefaultTTL(prefix string, ttl time.Duration) {
+	cache.availableMutex.Lock()
+	defer cache.availableMutex.Unlock()
+	cache.defaultTTLs[prefix] = ttl
+}
+
+// Returns the default TTL for path, false if no prefix matches. Expects the
+// available mutex to be held
+func (cache *FileCache) pathDefaultTTL(path string) (time.Duration, bool) {
+	var ttl time.Duration
+	longest := -1
+
+	for prefix, prefixTTL := range cache.defaultTTLs {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longest {
+			ttl = prefixTTL
+			longest = len(prefix)
+		}
+	}
+
+	return ttl, longest >= 0
+}
+
+// Returns when an entry for path fetched at fetchedAt goes stale, zero if it
+// never does. Expects the available mutex to be held
+func (cache *FileCache) pathExpiresAt(path string, headers http.Header, fetchedAt time.Time) time.Time {
+	lifetime, found := headersFreshness(headers, fetchedAt)
+
+	if !found {
+		lifetime, found = cache.pathDefaultTTL(path)
+	}
+
+	if !found {
+		return time.Time{}
+	}
+
+	return fetchedAt.Add(lifetime)
+}
+
+// Cache-Control extensions for serving stale responses, RFC 5861
+const (
+	staleWhileRevalidate = "stale-while-revalidate"
+	staleIfError         = "stale-if-error"
+)
+
+// Returns how long past expiry a response with headers can be served for
+// directive, fallback if the backend didn't send it. Responses that must be
+// revalidated are never served stale
+func staleWindow(headers http.Header, directive string, fallback time.Duration) time.Duration {
+	directives := parseCacheControl(strings.Join(headers["Cache-Control"], ","))
+
+	for _, name := range []string{"no-cache", "must-revalidate", "proxy-revalidate"} {
+		if _, found := directives[name]; found {
+			return 0
+		}
+	}
+
+	value, found := directives[directive]
+
+	if !found {
+		return fallback
+	}
+
+	seconds, err := strconv.ParseInt(value, 10, 64)
+
+	if err != nil || seconds < 0 {
+		return 0
+	}
+
+	return time.Duration(seconds) * time.Second
+}
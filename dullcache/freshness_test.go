@@ -0,0 +1,105 @@
+package dullcache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHeadersFreshness(t *testing.T) {
+	fetchedAt := time.Date(2016, 1, 2, 15, 0, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		headers  http.Header
+		lifetime time.Duration
+		found    bool
+	}{
+		{http.Header{}, 0, false},
+		{http.Header{"Cache-Control": {"public, max-age=60"}}, time.Minute, true},
+		{http.Header{"Cache-Control": {"max-age=60, s-maxage=120"}}, 2 * time.Minute, true},
+		{http.Header{"Cache-Control": {"max-age=60"}, "Age": {"20"}}, 40 * time.Second, true},
+		{http.Header{"Cache-Control": {"no-cache"}}, 0, true},
+		{http.Header{"Cache-Control": {"private, max-age=0"}}, 0, false},
+		{http.Header{
+			"Cache-Control": {"private, max-age=0"},
+			"Expires":       {"Sat, 02 Jan 2016 15:00:00 GMT"},
+		}, 0, false},
+		{http.Header{"Cache-Control": {"private, s-maxage=60"}}, time.Minute, true},
+		{http.Header{"Cache-Control": {"max-age=bad"}}, 0, true},
+		{http.Header{
+			"Date":    {"Sat, 02 Jan 2016 15:00:00 GMT"},
+			"Expires": {"Sat, 02 Jan 2016 16:00:00 GMT"},
+		}, time.Hour, true},
+		{http.Header{"Expires": {"Sat, 02 Jan 2016 15:10:00 GMT"}}, 10 * time.Minute, true},
+		{http.Header{"Expires": {"0"}}, 0, true},
+		{http.Header{
+			"Cache-Control": {"max-age=5"},
+			"Expires":       {"Sat, 02 Jan 2016 16:00:00 GMT"},
+		}, 5 * time.Second, true},
+	} {
+		lifetime, found := headersFreshness(test.headers, fetchedAt)
+
+		if lifetime != test.lifetime || found != test.found {
+			t.Error("unexpected freshness for", test.headers, lifetime, found)
+		}
+	}
+}
+
+func TestEntryExpiresAt(t *testing.T) {
+	cache := NewFileCache("cache")
+	cache.SetDefaultTTL("/bucket/", time.Minute)
+	cache.SetDefaultTTL("/bucket/long/", time.Hour)
+
+	fetchedAt := time.Now()
+
+	entry := cache.MarkPathAvailableAt("/other/file.txt", http.Header{}, fetchedAt)
+	if !entry.ExpiresAt.IsZero() || entry.Stale(fetchedAt.Add(time.Hour)) {
+		t.Error("expected entry without freshness info to never go stale")
+	}
+
+	entry = cache.MarkPathAvailableAt("/bucket/file.txt", http.Header{}, fetchedAt)
+	if !entry.ExpiresAt.Equal(fetchedAt.Add(time.Minute)) {
+		t.Error("expected default TTL for prefix, got", entry.ExpiresAt)
+	}
+
+	entry = cache.MarkPathAvailableAt("/bucket/long/file.txt", http.Header{}, fetchedAt)
+	if !entry.ExpiresAt.Equal(fetchedAt.Add(time.Hour)) {
+		t.Error("expected longest prefix TTL, got", entry.ExpiresAt)
+	}
+
+	entry = cache.MarkPathAvailableAt("/bucket/file.txt", http.Header{
+		"Cache-Control": {"max-age=10"},
+	}, fetchedAt)
+
+	if !entry.ExpiresAt.Equal(fetchedAt.Add(10 * time.Second)) {
+		t.Error("expected headers to override default TTL, got", entry.ExpiresAt)
+	}
+
+	if entry.Stale(fetchedAt.Add(5*time.Second)) || !entry.Stale(fetchedAt.Add(10*time.Second)) {
+		t.Error("expected entry to go stale after max-age")
+	}
+}
+
+func TestStaleWindow(t *testing.T) {
+	fallback := time.Minute
+
+	for _, test := range []struct {
+		cacheControl string
+		window       time.Duration
+	}{
+		{"", fallback},
+		{"max-age=60", fallback},
+		{"max-age=60, stale-while-revalidate=30", 30 * time.Second},
+		{"max-age=60, stale-if-error=30", fallback},
+		{"max-age=60, stale-while-revalidate=30, must-revalidate", 0},
+		{"no-cache", 0},
+		{"stale-while-revalidate=bad", 0},
+	} {
+		headers := http.Header{"Cache-Control": {test.cacheControl}}
+		window := staleWindow(headers, staleWhileRevalidate, fallback)
+
+		if window != test.window {
+			t.Error("unexpected window for", test.cacheControl, window)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package dullcache
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/titanous/goagain"
+	"github.com/titanous/manners"
+)
+
+var errListenerClosed = errors.New("listener has been gracefully closed")
+
+// Stops accepting without closing the socket, which a new process may have
+// inherited. Accept polls so it notices when it's closed
+type gracefulListener struct {
+	net.Listener
+	closed chan struct{}
+}
+
+func (l gracefulListener) Accept() (net.Conn, error) {
+	for {
+		select {
+		case <-l.closed:
+			return nil, errListenerClosed
+		default:
+		}
+
+		l.Listener.(*net.TCPListener).SetDeadline(time.Now().Add(100 * time.Millisecond))
+		conn, err := l.Listener.Accept()
+
+		if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
+			continue
+		}
+
+		return conn, err
+	}
+}
+
+func (l gracefulListener) Close() error {
+	close(l.closed)
+	return nil
+}
+
+// Blocks until a signal tells the process to exit, handling signals the way
+// goagain does. The index is saved before forking on SIGUSR2 so the new
+// process loads every path stored so far
+func (server *Server) waitSignals(l net.Listener) syscall.Signal {
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT,
+		syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	forked := false
+
+	for {
+		sig := <-ch
+		log.Print(sig)
+
+		switch sig {
+		case syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM:
+			return sig.(syscall.Signal)
+		case syscall.SIGUSR1:
+			log.Print("Reopening access log: ", server.config.AccessLogPath)
+
+			if err := server.ReopenAccessLog(); err != nil {
+				log.Print("Warning: failed to reopen access log: ", err)
+			}
+		case syscall.SIGUSR2:
+			// a second SIGUSR2 means the new process failed to take over
+			if forked {
+				return syscall.SIGUSR2
+			}
+
+			if err := server.fileCache.SaveIndex(); err != nil {
+				log.Print("Warning: failed to save index: ", err)
+			}
+
+			if err := goagain.ForkExec(l); err != nil {
+				log.Print("Warning: failed to fork: ", err)
+				continue
+			}
+
+			forked = true
+		}
+	}
+}
+
+// Serves on Address, or the listener inherited from the process being
+// replaced, until a signal says to exit. SIGQUIT and SIGUSR2 wait for
+// connections in progress to finish. The server is closed before returning
+// so the index is saved however the process exits
+func (server *Server) listenAndServe() error {
+	defer server.Close()
+
+	l, err := goagain.Listener()
+	inherited := err == nil
+
+	if !inherited {
+		l, err = net.Listen("tcp", server.config.Address)
+
+		if err != nil {
+			return err
+		}
+
+		log.Print("Listening on ", l.Addr())
+	} else {
+		log.Print("Resuming listening on ", l.Addr())
+	}
+
+	srv := manners.NewServer()
+	listener := manners.NewListener(gracefulListener{Listener: l, closed: make(chan struct{})}, srv)
+	done := make(chan struct{})
+
+	go func() {
+		srv.Serve(listener, server.Handler())
+		close(done)
+	}()
+
+	// tell the process we're replacing to finish up and exit
+	if inherited {
+		if err := goagain.Kill(); err != nil {
+			return err
+		}
+	}
+
+	sig := server.waitSignals(l)
+
+	if sig == syscall.SIGQUIT || sig == syscall.SIGUSR2 {
+		listener.Close()
+		<-done
+	}
+
+	return nil
+}
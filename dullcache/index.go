@@ -0,0 +1,113 @@
+package dullcache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"time"
+)
+
+// Name of the snapshot of available paths stored in the cache directory. Cache
+// files are base58 encoded so they never collide with it
+const indexFname = "index.json"
+
+type indexEntry struct {
+	Path       string
+	Headers    http.Header
+	FetchedAt  time.Time
+	AccessedAt int64 `json:",omitempty"`
+}
+
+func (cache *FileCache) indexPath() string {
+	return path.Join(cache.basePath, indexFname)
+}
+
+// Write a snapshot of every available path, its headers and fetch time into
+// the cache directory so they can be restored after a restart
+func (cache *FileCache) SaveIndex() error {
+	cache.availableMutex.RLock()
+	entries := make([]indexEntry, 0, len(cache.availablePaths))
+	for path, entry := range cache.availablePaths {
+		entries = append(entries, indexEntry{
+			Path:      path,
+			Headers:   entry.Headers,
+			FetchedAt: entry.FetchedAt,
+		})
+	}
+	cache.availableMutex.RUnlock()
+
+	cache.accessList.mutex.RLock()
+	for i := range entries {
+		entries[i].AccessedAt = cache.accessList.pathTimes[entries[i].Path]
+	}
+	cache.accessList.mutex.RUnlock()
+
+	err := os.MkdirAll(cache.basePath, 0755)
+
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(cache.basePath, tempFilePrefix+indexFname)
+
+	if err != nil {
+		return err
+	}
+
+	err = json.NewEncoder(file).Encode(entries)
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return os.Rename(file.Name(), cache.indexPath())
+}
+
+// Restore available paths from the snapshot written by SaveIndex. Entries
+// whose cache file is missing or doesn't match the stored Content-Length are
+// skipped. Returns the number of paths restored
+func (cache *FileCache) LoadIndex() (int, error) {
+	jsonBlob, err := ioutil.ReadFile(cache.indexPath())
+
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	var entries []indexEntry
+	err = json.Unmarshal(jsonBlob, &entries)
+
+	if err != nil {
+		return 0, err
+	}
+
+	loaded := 0
+
+	for _, entry := range entries {
+		size, err := cache.PathMaybeAvailable(entry.Path)
+
+		if err != nil || size == 0 || size != headersContentLength(entry.Headers) {
+			continue
+		}
+
+		cache.MarkPathAvailableAt(entry.Path, entry.Headers, entry.FetchedAt)
+
+		if entry.AccessedAt != 0 {
+			cache.accessList.AccessPathAt(entry.Path, entry.AccessedAt)
+		}
+
+		loaded += 1
+	}
+
+	return loaded, nil
+}
@@ -0,0 +1,90 @@
+package dullcache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSaveAndLoadIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dullcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	cache := NewFileCache(dir)
+
+	for _, path := range []string{"/hello/world.png", "/missing.png"} {
+		file, err := cache.PathWriter(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		file.Write([]byte("hello"))
+		if err := cache.CommitPathWriter(path, file, -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fetchedAt := time.Unix(1400000000, 0)
+
+	cache.MarkPathAvailableAt("/hello/world.png", http.Header{
+		"Content-Length": []string{"5"},
+		"Content-Type":   []string{"image/png"},
+	}, fetchedAt)
+	cache.accessList.AccessPathAt("/hello/world.png", 1500000000)
+
+	cache.MarkPathAvailable("/missing.png", http.Header{
+		"Content-Length": []string{"5"},
+	})
+
+	if err := cache.SaveIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	// file removed behind the cache's back shouldn't be restored
+	fname, _ := cache.CacheFilePath("/missing.png")
+	os.Remove(fname)
+
+	restored := NewFileCache(dir)
+	loaded, err := restored.LoadIndex()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded != 1 {
+		t.Fatal("expected 1 path to be loaded, got", loaded)
+	}
+
+	entry := restored.PathEntry("/hello/world.png")
+
+	if entry == nil {
+		t.Fatal("expected path to be available after load")
+	}
+
+	if entry.Headers.Get("Content-Type") != "image/png" {
+		t.Error("expected headers to be restored, got", entry.Headers)
+	}
+
+	if !entry.FetchedAt.Equal(fetchedAt) {
+		t.Error("expected fetch time to be restored, got", entry.FetchedAt)
+	}
+
+	if restored.accessList.pathTimes["/hello/world.png"] != 1500000000 {
+		t.Error("expected access time to be restored")
+	}
+}
+
+func TestLoadMissingIndex(t *testing.T) {
+	cache := NewFileCache("test_cache_missing")
+	loaded, err := cache.LoadIndex()
+
+	if err != nil || loaded != 0 {
+		t.Error("expected missing index to load nothing, got", loaded, err)
+	}
+}
@@ -0,0 +1,146 @@
+package dullcache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// scheme of origin URLs that are directories on the local filesystem, as in
+// file:///srv/objects
+const localOriginScheme = "file"
+
+// Sends requests for local origins to the filesystem and everything else to
+// remote
+type originTransport struct {
+	remote http.RoundTripper
+}
+
+func newOriginTransport() http.RoundTripper {
+	return &originTransport{remote: http.DefaultTransport}
+}
+
+func (transport *originTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != localOriginScheme {
+		return transport.remote.RoundTrip(req)
+	}
+
+	body, pipe := io.Pipe()
+
+	w := &localResponseWriter{
+		res: &http.Response{
+			Proto:      "HTTP/1.0",
+			ProtoMajor: 1,
+			Header:     http.Header{},
+			Body:       body,
+			Request:    req,
+		},
+		pipe:  pipe,
+		ready: make(chan struct{}),
+	}
+
+	go func() {
+		serveLocalFile(w, req)
+		w.finish()
+	}()
+
+	<-w.ready
+	return w.res, nil
+}
+
+// Streams a response served by a handler back as the response of a round
+// trip. The response is ready once the header is written
+type localResponseWriter struct {
+	res         *http.Response
+	pipe        *io.PipeWriter
+	ready       chan struct{}
+	wroteHeader bool
+}
+
+func (w *localResponseWriter) Header() http.Header {
+	return w.res.Header
+}
+
+func (w *localResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+
+	w.wroteHeader = true
+	w.res.StatusCode = status
+	w.res.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+	w.res.ContentLength = -1
+
+	if length, err := strconv.ParseInt(w.res.Header.Get("Content-Length"), 10, 64); err == nil {
+		w.res.ContentLength = length
+	}
+
+	close(w.ready)
+}
+
+func (w *localResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.pipe.Write(p)
+}
+
+func (w *localResponseWriter) finish() {
+	w.WriteHeader(http.StatusOK)
+	w.pipe.Close()
+}
+
+// Entity tag for a local file, from its modification time and size
+func localFileEtag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+// Serves the file at the path of the request URL like an object store would.
+// Content-Length, Content-Type and Last-Modified come from ServeContent, which
+// also handles ranges and conditional requests against the synthesized Etag
+func serveLocalFile(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Path
+
+	// the path is the origin directory followed by the requested path, don't
+	// let it leave the directory
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	file, err := os.Open(filepath.FromSlash(name))
+
+	if err != nil {
+		switch {
+		case os.IsNotExist(err):
+			http.NotFound(w, r)
+		case os.IsPermission(err):
+			http.Error(w, "forbidden", http.StatusForbidden)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Etag", localFileEtag(info))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
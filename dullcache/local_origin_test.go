@@ -0,0 +1,163 @@
+package dullcache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Creates a local origin directory with the given files and points the default
+// route of the server at it
+func setupLocalOrigin(t *testing.T, server *Server, files map[string]string) func() {
+	dir, err := ioutil.TempDir("", "dullcache-origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, body := range files {
+		fname := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(fname, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server.defaultRoute.origins = newOrigins("file://"+filepath.ToSlash(dir), nil)
+
+	return func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestLocalOrigin(t *testing.T) {
+	server, cleanup := setupTestServer(t, nil)
+	defer cleanup()
+
+	defer setupLocalOrigin(t, server, map[string]string{
+		"/bucket/file name.txt": "0123456789",
+	})()
+
+	get := func(uri string, header http.Header) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", uri, nil)
+
+		for k, v := range header {
+			req.Header[k] = v
+		}
+
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/bucket/file%20name.txt", nil)
+
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789" {
+		t.Fatal("expected file from local origin, got", w.Code, w.Body.String())
+	}
+
+	if w.Header().Get("X-Cache") != "MISS" {
+		t.Error("expected X-Cache MISS, got", w.Header().Get("X-Cache"))
+	}
+
+	entry := server.fileCache.PathEntry("/bucket/file name.txt")
+
+	if entry == nil {
+		t.Fatal("expected local file to be stored")
+	}
+
+	for _, k := range []string{"Content-Length", "Last-Modified", "Content-Type", "Etag"} {
+		if entry.Headers.Get(k) == "" {
+			t.Error("expected stored header", k)
+		}
+	}
+
+	if entry.Headers.Get("Content-Length") != "10" || entry.Headers.Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Error("unexpected stored headers", entry.Headers)
+	}
+
+	w = get("/bucket/file%20name.txt", nil)
+
+	if w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "0123456789" {
+		t.Error("expected cache hit, got", w.Header().Get("X-Cache"))
+	}
+
+	w = get("/bucket/other.txt", http.Header{"Range": []string{"bytes=2-4"}})
+
+	if w.Code != http.StatusNotFound {
+		t.Error("expected 404 for missing local file, got", w.Code)
+	}
+
+	server.fileCache.DeletePath("/bucket/file name.txt")
+	w = get("/bucket/file%20name.txt", http.Header{"Range": []string{"bytes=2-4"}})
+
+	if w.Code != http.StatusPartialContent || w.Body.String() != "234" {
+		t.Error("expected range from local origin, got", w.Code, w.Body.String())
+	}
+
+	outside, _ := http.NewRequest("GET", "/../../etc/passwd", nil)
+	res, err := server.doRemote(server.defaultRoute, outside, false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusNotFound {
+		t.Error("expected path outside the origin directory to be refused, got", res.StatusCode)
+	}
+}
+
+func TestLocalOriginHead(t *testing.T) {
+	server, cleanup := setupTestServer(t, nil)
+	defer cleanup()
+
+	defer setupLocalOrigin(t, server, map[string]string{
+		"/bucket/data.json": `{"a": 1}`,
+	})()
+
+	req, _ := http.NewRequest("HEAD", "/bucket/data.json", nil)
+	headers, err := server.headPath(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if headers.Get("Content-Length") != "8" || headers.Get("Content-Type") != "application/json" {
+		t.Error("unexpected HEAD headers", headers)
+	}
+
+	etag := headers.Get("Etag")
+
+	if etag == "" {
+		t.Fatal("expected synthesized Etag")
+	}
+
+	remoteReq, _ := http.NewRequest("GET", "/bucket/data.json", nil)
+	remoteReq.Header.Set("If-None-Match", etag)
+
+	res, err := server.doRemote(server.defaultRoute, remoteReq, false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusNotModified {
+		t.Error("expected 304 for matching Etag, got", res.StatusCode)
+	}
+
+	req, _ = http.NewRequest("HEAD", "/bucket", nil)
+
+	if _, err := server.headPath(req); originFailed(err) {
+		t.Error("expected directory to be missing rather than failing, got", err)
+	}
+}
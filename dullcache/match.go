@@ -0,0 +1,54 @@
+package dullcache
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Builds a path matcher from the prefix, glob or regex parameter of an admin
+// request, exactly one has to be set. Globs use path.Match syntax so * doesn't
+// match across slashes
+func requestPathMatcher(values url.Values) (func(string) bool, error) {
+	var match func(string) bool
+	given := 0
+
+	if prefix := values.Get("prefix"); prefix != "" {
+		given += 1
+		match = func(p string) bool {
+			return strings.HasPrefix(p, prefix)
+		}
+	}
+
+	if glob := values.Get("glob"); glob != "" {
+		given += 1
+
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob: %v", err)
+		}
+
+		match = func(p string) bool {
+			matched, _ := path.Match(glob, p)
+			return matched
+		}
+	}
+
+	if pattern := values.Get("regex"); pattern != "" {
+		given += 1
+		re, err := regexp.Compile(pattern)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %v", err)
+		}
+
+		match = re.MatchString
+	}
+
+	if given != 1 {
+		return nil, fmt.Errorf("expected one of prefix, glob or regex")
+	}
+
+	return match, nil
+}
@@ -0,0 +1,41 @@
+package dullcache
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestRequestPathMatcher(t *testing.T) {
+	for _, test := range []struct {
+		kind    string
+		pattern string
+		path    string
+		matched bool
+	}{
+		{"prefix", "/builds/", "/builds/1/a.zip", true},
+		{"prefix", "/builds/", "/other/a.zip", false},
+		{"glob", "/builds/*/a.zip", "/builds/1/a.zip", true},
+		{"glob", "/builds/*", "/builds/1/a.zip", false},
+		{"regex", `^/builds/[0-9]+/.*\.zip$`, "/builds/12/a.zip", true},
+		{"regex", `^/builds/[0-9]+/`, "/builds/latest/a.zip", false},
+	} {
+		match, err := requestPathMatcher(url.Values{test.kind: {test.pattern}})
+
+		if err != nil {
+			t.Error("failed to build matcher for", test.kind, test.pattern, err)
+			continue
+		}
+
+		if match(test.path) != test.matched {
+			t.Error("unexpected match of", test.path, "for", test.kind, test.pattern)
+		}
+	}
+
+	for _, query := range []string{"", "prefix=/a&glob=/b", "glob=[", "regex=("} {
+		values, _ := url.ParseQuery(query)
+
+		if _, err := requestPathMatcher(values); err == nil {
+			t.Error("expected error for", query)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package dullcache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const metricsPrefix = "dullcache_"
+
+func writeMetric(w io.Writer, name, kind, help string, value interface{}) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n", metricsPrefix, name, help)
+	fmt.Fprintf(w, "# TYPE %s%s %s\n", metricsPrefix, name, kind)
+	fmt.Fprintf(w, "%s%s %v\n", metricsPrefix, name, value)
+}
+
+// Writes the size distribution of completed transfers as a histogram, with
+// buckets bounded by sizeDistsMB
+func (server *Server) writeSizeHistogram(w io.Writer) {
+	name := metricsPrefix + "transfer_size_bytes"
+	fmt.Fprintf(w, "# HELP %s Size of completed transfers to clients.\n", name)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+
+	var cumulative uint64
+
+	for i := range sizeDistsMB {
+		cumulative += server.stats.sizeHistogram[i]
+
+		le := "+Inf"
+		if i+1 < len(sizeDistsMB) {
+			le = fmt.Sprint(sizeDistsMB[i+1] * 1024 * 1024)
+		}
+
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %v\n", name, le, cumulative)
+	}
+
+	fmt.Fprintf(w, "%s_sum %v\n", name, server.stats.sizeDistBytes)
+	fmt.Fprintf(w, "%s_count %v\n", name, cumulative)
+}
+
+// Renders the server stats in the Prometheus text exposition format
+func (server *Server) metricsHandler(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	if r.Method == "HEAD" {
+		return nil
+	}
+
+	availablePaths := server.fileCache.CountAvailablePaths()
+	busyPaths := server.fileCache.CountBusyPaths()
+	purgedPaths := server.fileCache.CountPurgedPaths()
+	trackedSize := server.fileCache.TrackedSize()
+	tags := server.fileCache.CountTags()
+	missingPaths := server.fileCache.CountMissingPaths()
+	unhealthyOrigins := server.countUnhealthyOrigins()
+
+	server.stats.RLock()
+	defer server.stats.RUnlock()
+
+	writeMetric(w, "fast_hits_total", "counter",
+		"Requests served from the cache without checking the backend.", server.stats.fastHits)
+	writeMetric(w, "checked_hits_total", "counter",
+		"Requests served from the cache after checking the backend.", server.stats.checkedHits)
+	writeMetric(w, "coalesced_hits_total", "counter",
+		"Requests served from a cache fill in progress.", server.stats.coalesced)
+	writeMetric(w, "revalidations_total", "counter",
+		"Conditional requests sent to the backend for stale paths.", server.stats.revalidations)
+	writeMetric(w, "stale_hits_total", "counter",
+		"Requests served from a stale or unverified file in the cache.", server.stats.staleHits)
+	writeMetric(w, "not_modified_total", "counter",
+		"Requests answered with 304 Not Modified from the cache.", server.stats.notModified)
+	writeMetric(w, "negative_hits_total", "counter",
+		"Requests answered with an error remembered from the backend.", server.stats.negativeHits)
+	writeMetric(w, "head_hits_total", "counter",
+		"HEAD requests answered from stored headers.", server.stats.headHits)
+	writeMetric(w, "head_misses_total", "counter",
+		"HEAD requests answered with a HEAD to the backend.", server.stats.headMisses)
+	writeMetric(w, "passes_total", "counter",
+		"Requests passed through to the backend without caching.", server.stats.passes)
+	writeMetric(w, "stores_total", "counter",
+		"Requests that stored a file in the cache.", server.stats.stores)
+	writeMetric(w, "evictions_total", "counter",
+		"Paths evicted from the cache.", server.stats.evictions)
+	writeMetric(w, "bytes_fetched_total", "counter",
+		"Bytes fetched from the backend.", server.stats.bytesFetched)
+	writeMetric(w, "bytes_sent_total", "counter",
+		"Bytes sent to clients.", server.stats.bytesSent)
+	writeMetric(w, "bytes_evicted_total", "counter",
+		"Bytes evicted from the cache.", server.stats.bytesEvicted)
+
+	writeMetric(w, "available_paths", "gauge",
+		"Paths available to be served from the cache.", availablePaths)
+	writeMetric(w, "busy_paths", "gauge",
+		"Paths currently being written.", busyPaths)
+	writeMetric(w, "purged_paths", "gauge",
+		"Paths waiting to be refetched after a purge.", purgedPaths)
+	writeMetric(w, "missing_paths", "gauge",
+		"Paths with an error response remembered from the backend.", missingPaths)
+	writeMetric(w, "tags", "gauge",
+		"Surrogate keys with available paths.", tags)
+	writeMetric(w, "tracked_bytes", "gauge",
+		"Total Content-Length of available paths.", trackedSize)
+	writeMetric(w, "unhealthy_origins", "gauge",
+		"Origins skipped after failing requests or health checks.", unhealthyOrigins)
+	writeMetric(w, "active_transfers", "gauge",
+		"Paths currently being transferred to clients.", len(server.stats.activePaths))
+
+	server.writeSizeHistogram(w)
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package dullcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	server, cleanup := setupTestServer(t, map[string]string{
+		"/bucket/file.txt": "0123456789",
+	})
+	defer cleanup()
+
+	server.stats.incrFastHits(3)
+	server.stats.incrSizeDist(500)
+	server.stats.incrSizeDist(1024 * 1024)
+	server.stats.incrSizeDist(5 * 1024 * 1024)
+	server.stats.incrSizeDist(10 * 1024 * 1024)
+	server.stats.incrSizeDist(1024 * 1024 * 1024)
+
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	errorHandler(server.metricsHandler).ServeHTTP(w, req)
+
+	body := w.Body.String()
+
+	for _, line := range []string{
+		"# TYPE dullcache_fast_hits_total counter",
+		"dullcache_fast_hits_total 3",
+		"dullcache_available_paths 1",
+		"dullcache_tracked_bytes 10",
+		`dullcache_transfer_size_bytes_bucket{le="1048576"} 2`,
+		`dullcache_transfer_size_bytes_bucket{le="10485760"} 4`,
+		`dullcache_transfer_size_bytes_bucket{le="20971520"} 4`,
+		`dullcache_transfer_size_bytes_bucket{le="+Inf"} 5`,
+		"dullcache_transfer_size_bytes_count 5",
+		"dullcache_transfer_size_bytes_sum 1090519540",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Error("expected metrics to contain", line)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package dullcache
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Responses with larger bodies aren't negatively cached
+const negativeBodyMaxBytes = 64 * 1024
+
+// Most negative entries kept in memory at once
+const negativeMaxEntries = 10000
+
+// An error response from the backend remembered for a path that couldn't be
+// fetched
+type NegativeEntry struct {
+	Status    int
+	Headers   http.Header
+	Body      []byte
+	ExpiresAt time.Time
+}
+
+// Remember an error response for path until ttl passes. Expired entries are
+// dropped when the cache is full, nothing is added if it's still full
+func (cache *FileCache) MarkPathMissing(path string, status int, headers http.Header, body []byte, ttl time.Duration) bool {
+	cache.missingMutex.Lock()
+	defer cache.missingMutex.Unlock()
+
+	now := time.Now()
+
+	if len(cache.missingPaths) >= negativeMaxEntries {
+		for missingPath, entry := range cache.missingPaths {
+			if !now.Before(entry.ExpiresAt) {
+				delete(cache.missingPaths, missingPath)
+			}
+		}
+	}
+
+	if len(cache.missingPaths) >= negativeMaxEntries {
+		return false
+	}
+
+	cache.missingPaths[path] = &NegativeEntry{
+		Status:    status,
+		Headers:   headers,
+		Body:      body,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	return true
+}
+
+// Returns the unexpired negative entry for path, nil if there isn't one
+func (cache *FileCache) PathMissing(path string) *NegativeEntry {
+	cache.missingMutex.RLock()
+	entry := cache.missingPaths[path]
+	cache.missingMutex.RUnlock()
+
+	if entry == nil || time.Now().Before(entry.ExpiresAt) {
+		return entry
+	}
+
+	cache.missingMutex.Lock()
+	defer cache.missingMutex.Unlock()
+
+	if cache.missingPaths[path] == entry {
+		delete(cache.missingPaths, path)
+	}
+
+	return nil
+}
+
+// Forget the negative entry for path. Returns true if there was one
+func (cache *FileCache) ReleasePathMissing(path string) bool {
+	cache.missingMutex.Lock()
+	defer cache.missingMutex.Unlock()
+
+	_, found := cache.missingPaths[path]
+	delete(cache.missingPaths, path)
+	return found
+}
+
+// Returns the total number of paths with negative entries, including expired
+// ones that haven't been dropped yet
+func (cache *FileCache) CountMissingPaths() int {
+	cache.missingMutex.RLock()
+	defer cache.missingMutex.RUnlock()
+	return len(cache.missingPaths)
+}
+
+// Checks if backend responses with status are negatively cached
+func (server *Server) negativeCacheable(status int) bool {
+	if server.config.NegativeCacheTTL <= 0 {
+		return false
+	}
+
+	for _, cacheable := range server.config.NegativeCacheStatuses {
+		if cacheable == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Sends an error response from the backend to the client, remembering it for
+// the path if the body is small enough
+func (server *Server) storeNegative(w http.ResponseWriter, r *http.Request, remoteRes *http.Response) error {
+	subPath := server.cacheKey(r)
+	body, err := ioutil.ReadAll(io.LimitReader(remoteRes.Body, negativeBodyMaxBytes+1))
+
+	if err != nil {
+		return err
+	}
+
+	if len(body) <= negativeBodyMaxBytes {
+		ttl := time.Duration(server.config.NegativeCacheTTL) * time.Second
+		headers := filterHeaders(remoteRes.Header)
+
+		if server.fileCache.MarkPathMissing(subPath, remoteRes.StatusCode, headers, body, ttl) {
+			log.Print("Negative cache stored: ", subPath, " ", remoteRes.StatusCode)
+		}
+	}
+
+	passHeaders(w, remoteRes.Header)
+	w.Header().Set(cacheStatusHeader, cacheStatusStore)
+	w.WriteHeader(remoteRes.StatusCode)
+
+	server.stats.incrActivePath(subPath, 1)
+	copied, err := io.Copy(w, io.MultiReader(bytes.NewReader(body), remoteRes.Body))
+	server.stats.incrActivePath(subPath, -1)
+
+	server.stats.incrBytesSent(uint64(copied))
+	return err
+}
+
+// Responds with the error remembered for a path
+func (server *Server) serveNegative(w http.ResponseWriter, r *http.Request, entry *NegativeEntry) error {
+	log.Print("From negative cache: ", server.cacheKey(r))
+	server.stats.incrNegativeHits(1)
+
+	passHeaders(w, entry.Headers)
+	w.Header().Set(cacheStatusHeader, cacheStatusNegative)
+	w.WriteHeader(entry.Status)
+
+	if r.Method == "HEAD" {
+		return nil
+	}
+
+	copied, err := w.Write(entry.Body)
+	server.stats.incrBytesSent(uint64(copied))
+	return err
+}
@@ -0,0 +1,115 @@
+package dullcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPathMissing(t *testing.T) {
+	cache := getCache()
+
+	cache.MarkPathMissing("/gone.txt", 404, http.Header{}, []byte("not found"), time.Minute)
+	cache.MarkPathMissing("/expired.txt", 404, http.Header{}, nil, -time.Second)
+
+	entry := cache.PathMissing("/gone.txt")
+	if entry == nil || entry.Status != 404 || string(entry.Body) != "not found" {
+		t.Error("expected negative entry for path")
+	}
+
+	if cache.PathMissing("/expired.txt") != nil {
+		t.Error("expected expired entry to be ignored")
+	}
+
+	if cache.CountMissingPaths() != 1 {
+		t.Error("expected expired entry to be dropped, got", cache.CountMissingPaths())
+	}
+
+	if !cache.ReleasePathMissing("/gone.txt") || cache.PathMissing("/gone.txt") != nil {
+		t.Error("expected entry to be released")
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	server, cleanup := setupTestServer(t, nil)
+	defer cleanup()
+
+	server.config.NegativeCacheTTL = 60
+	originRequests := 0
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		originRequests += 1
+
+		if r.URL.Path == "/bucket/error.txt" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("no such key"))
+	}))
+	defer origin.Close()
+
+	server.defaultRoute.origins = newOrigins(origin.URL, nil)
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("GET", "/bucket/missing.txt")
+
+	if w.Code != http.StatusNotFound || w.Body.String() != "no such key" {
+		t.Error("expected origin 404, got", w.Code, w.Body.String())
+	}
+
+	w = request("GET", "/bucket/missing.txt")
+
+	if w.Code != http.StatusNotFound || w.Body.String() != "no such key" {
+		t.Error("expected remembered 404, got", w.Code, w.Body.String())
+	}
+
+	if w.Header().Get("X-Cache") != "HIT-NEGATIVE" || w.Header().Get("Content-Type") != "text/plain" {
+		t.Error("unexpected negative hit headers", w.Header())
+	}
+
+	w = request("HEAD", "/bucket/missing.txt")
+
+	if w.Code != http.StatusNotFound || w.Body.Len() != 0 {
+		t.Error("expected HEAD to get remembered status without body, got", w.Code)
+	}
+
+	if originRequests != 1 || server.stats.negativeHits != 2 {
+		t.Error("expected negative hits to skip the origin", originRequests, server.stats.negativeHits)
+	}
+
+	// statuses that aren't configured are always fetched
+	request("GET", "/bucket/error.txt")
+	request("GET", "/bucket/error.txt")
+
+	if originRequests != 3 {
+		t.Error("expected 500 to not be remembered, got", originRequests)
+	}
+
+	w = request("DELETE", "/bucket/missing.txt")
+
+	if w.Code != http.StatusOK {
+		t.Fatal("purge failed", w.Code)
+	}
+
+	if server.fileCache.PathNeedsPurge("/bucket/missing.txt") {
+		t.Error("purging a missing path should only forget it")
+	}
+
+	request("GET", "/bucket/missing.txt")
+
+	if originRequests != 4 {
+		t.Error("expected purged path to be fetched again, got", originRequests)
+	}
+}
@@ -0,0 +1,66 @@
+package dullcache
+
+import (
+	"net/http"
+)
+
+// Signs requests dullcache sends to an origin so private objects can be
+// fetched with credentials from the config
+type originSigner interface {
+	// Checks if GETs for path are signed, instead of relying on the signature
+	// sent by the client
+	SignsPath(path string) bool
+
+	// Signs req in place. The URL of req is the full URL of the origin it's
+	// about to be sent to
+	SignRequest(req *http.Request) error
+}
+
+// Signs GCS requests with V2 signed URLs. GETs are only signed for the
+// configured buckets, HEADs are signed for any bucket since the client's
+// signature isn't forwarded with them
+type gcsOriginSigner struct {
+	signer  *urlSigner
+	buckets []string
+}
+
+func (gcs *gcsOriginSigner) SignsPath(path string) bool {
+	bucket, _, err := gcs.signer.SplitBucketAndName(path)
+
+	if err != nil {
+		return false
+	}
+
+	for _, signedBucket := range gcs.buckets {
+		if signedBucket == bucket {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Replaces the path and query of req with a signed one. Paths without a bucket
+// are left unsigned
+func (gcs *gcsOriginSigner) SignRequest(req *http.Request) error {
+	bucket, name, err := gcs.signer.SplitBucketAndName(req.URL.Path)
+
+	if err != nil {
+		return nil
+	}
+
+	signedPath, err := gcs.signer.SignPath(req.Method, bucket, name)
+
+	if err != nil {
+		return err
+	}
+
+	signedURL, err := req.URL.Parse(signedPath)
+
+	if err != nil {
+		return err
+	}
+
+	req.URL = signedURL
+	return nil
+}
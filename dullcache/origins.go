@@ -0,0 +1,261 @@
+package dullcache
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// timeout for health check requests to an origin
+const healthCheckTimeout = time.Duration(10) * time.Second
+
+// how long after failing an unhealthy origin is tried by requests again
+const originRetryInterval = time.Duration(30) * time.Second
+
+// One of the equivalent backends of a route. Origins are marked unhealthy when
+// a request to them fails and healthy again once the health checker or a
+// request reaches them
+type origin struct {
+	baseURL string
+
+	mutex     sync.RWMutex
+	healthy   bool
+	lastError string
+	changedAt time.Time
+	failedAt  time.Time
+}
+
+// Creates the origins for baseURL followed by its fallbacks, all starting out
+// healthy
+func newOrigins(baseURL string, fallbackURLs []string) []*origin {
+	var origins []*origin
+
+	for _, originURL := range append([]string{baseURL}, fallbackURLs...) {
+		origins = append(origins, &origin{
+			baseURL:   originURL,
+			healthy:   true,
+			changedAt: time.Now(),
+		})
+	}
+
+	return origins
+}
+
+func (o *origin) isHealthy() bool {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	return o.healthy
+}
+
+func (o *origin) markHealthy() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if !o.healthy {
+		log.Print("Origin healthy: ", o.baseURL)
+		o.healthy = true
+		o.changedAt = time.Now()
+	}
+}
+
+func (o *origin) markFailed(reason string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.healthy {
+		log.Print("Origin unhealthy: ", o.baseURL, ": ", reason)
+		o.healthy = false
+		o.changedAt = time.Now()
+	}
+
+	o.lastError = reason
+	o.failedAt = time.Now()
+}
+
+// Checks if requests should be sent to the origin, unhealthy origins are
+// retried once originRetryInterval has passed since they last failed
+func (o *origin) shouldTry() bool {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	return o.healthy || time.Since(o.failedAt) >= originRetryInterval
+}
+
+// Describes the health of the origin for the stat page
+func (o *origin) status() string {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	since := time.Since(o.changedAt) / time.Second * time.Second
+
+	if o.healthy {
+		return fmt.Sprintf("healthy for %v", since)
+	}
+
+	return fmt.Sprintf("unhealthy for %v: %v", since, o.lastError)
+}
+
+// Returns the origins of the route to try in order, or all of them if none
+// should be tried so requests still have somewhere to go
+func (route *originRoute) candidateOrigins() []*origin {
+	var candidates []*origin
+
+	for _, o := range route.origins {
+		if o.shouldTry() {
+			candidates = append(candidates, o)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return route.origins
+	}
+
+	return candidates
+}
+
+// Checks if a response from an origin means it's failing and the next origin
+// should be tried
+func originResponseFailed(res *http.Response, err error) (bool, string) {
+	if err != nil {
+		return true, err.Error()
+	}
+
+	if res.StatusCode >= 500 {
+		return true, fmt.Sprintf("status %v", res.StatusCode)
+	}
+
+	return false, ""
+}
+
+// Sends req, which has a URL relative to the base URL of the route, to the
+// origins of the route in turn until one responds without failing. Each
+// attempt is signed separately when sign is set. The response of the last
+// origin is returned if they all fail
+func (server *Server) doRemote(route *originRoute, req *http.Request, sign bool) (*http.Response, error) {
+	var res *http.Response
+	var err error
+
+	for i, o := range route.candidateOrigins() {
+		if res != nil {
+			res.Body.Close()
+		}
+
+		attempt := *req
+		attempt.URL, err = url.Parse(o.baseURL + req.URL.RequestURI())
+
+		if err != nil {
+			return nil, err
+		}
+
+		if sign {
+			attempt.Header = http.Header{}
+			for k, v := range req.Header {
+				attempt.Header[k] = v
+			}
+
+			if err := route.signer.SignRequest(&attempt); err != nil {
+				return nil, err
+			}
+		}
+
+		if i > 0 {
+			log.Print("Failing over to origin: ", o.baseURL, " ", req.URL.Path)
+		}
+
+		res, err = server.client.Do(&attempt)
+		failed, reason := originResponseFailed(res, err)
+
+		if !failed {
+			o.markHealthy()
+			return res, nil
+		}
+
+		o.markFailed(reason)
+	}
+
+	return res, err
+}
+
+// Probes the origin with a HEAD to HealthCheckPath, any response that isn't a
+// server error counts as healthy
+func (server *Server) checkOrigin(client *http.Client, o *origin) {
+	res, err := client.Head(o.baseURL + server.config.HealthCheckPath)
+
+	if err == nil {
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+	}
+
+	failed, reason := originResponseFailed(res, err)
+
+	if failed {
+		o.markFailed(reason)
+	} else {
+		o.markHealthy()
+	}
+}
+
+// Checks the origins of every route that has fallbacks. A route with a single
+// origin sends requests to it whether it's healthy or not, so it isn't probed
+func (server *Server) checkOrigins(client *http.Client) {
+	for _, route := range server.allRoutes() {
+		if len(route.origins) < 2 {
+			continue
+		}
+
+		for _, o := range route.origins {
+			server.checkOrigin(client, o)
+		}
+	}
+}
+
+// Periodically checks the health of the origins
+func (server *Server) runHealthChecker(interval time.Duration) {
+	client := &http.Client{Timeout: healthCheckTimeout, Transport: newOriginTransport()}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-server.done:
+			return
+		}
+
+		server.checkOrigins(client)
+	}
+}
+
+// Checks if any route has fallback origins that need health checks
+func (server *Server) hasFallbackOrigins() bool {
+	for _, route := range server.allRoutes() {
+		if len(route.origins) > 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the configured routes followed by the default route
+func (server *Server) allRoutes() []*originRoute {
+	return append(append([]*originRoute{}, server.routes...), server.defaultRoute)
+}
+
+func (server *Server) countUnhealthyOrigins() int {
+	count := 0
+
+	for _, route := range server.allRoutes() {
+		for _, o := range route.origins {
+			if !o.isHealthy() {
+				count += 1
+			}
+		}
+	}
+
+	return count
+}
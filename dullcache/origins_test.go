@@ -0,0 +1,205 @@
+package dullcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestOriginFailover(t *testing.T) {
+	server, cleanup := setupTestServer(t, nil)
+	defer cleanup()
+
+	var primaryRequests int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&primaryRequests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("mirror " + r.URL.Path))
+	}))
+	defer mirror.Close()
+
+	server.defaultRoute.origins = newOrigins(primary.URL, []string{mirror.URL})
+
+	for _, path := range []string{"/bucket/one.txt", "/bucket/two.txt"} {
+		req, _ := http.NewRequest("GET", path, nil)
+
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK || w.Body.String() != "mirror "+path {
+			t.Error("expected failover to mirror, got", w.Code, w.Body.String())
+		}
+	}
+
+	if n := atomic.LoadInt32(&primaryRequests); n != 1 {
+		t.Error("expected unhealthy primary to be skipped, got", n, "requests")
+	}
+
+	req, _ := http.NewRequest("GET", "/stat", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	w := httptest.NewRecorder()
+	server.AdminHandler().ServeHTTP(w, req)
+
+	if !strings.Contains(w.Body.String(), "default "+primary.URL+" unhealthy") {
+		t.Error("expected stat to show unhealthy primary, got", w.Body.String())
+	}
+
+	if !strings.Contains(w.Body.String(), "default "+mirror.URL+" healthy") {
+		t.Error("expected stat to show healthy mirror, got", w.Body.String())
+	}
+}
+
+func TestOriginRecovers(t *testing.T) {
+	server, cleanup := setupTestServer(t, nil)
+	defer cleanup()
+
+	var failing int32 = 1
+	var primaryRequests int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&primaryRequests, 1)
+
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Write([]byte("primary " + r.URL.Path))
+	}))
+	defer primary.Close()
+
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("mirror " + r.URL.Path))
+	}))
+	defer mirror.Close()
+
+	server.defaultRoute.origins = newOrigins(primary.URL, []string{mirror.URL})
+
+	get := func(path string) string {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	if body := get("/bucket/one.txt"); body != "mirror /bucket/one.txt" {
+		t.Error("expected failover to mirror, got", body)
+	}
+
+	atomic.StoreInt32(&failing, 0)
+
+	if body := get("/bucket/two.txt"); body != "mirror /bucket/two.txt" {
+		t.Error("expected failed primary to be skipped, got", body)
+	}
+
+	o := server.defaultRoute.origins[0]
+	o.mutex.Lock()
+	o.failedAt = o.failedAt.Add(-originRetryInterval)
+	o.mutex.Unlock()
+
+	for _, path := range []string{"/bucket/three.txt", "/bucket/four.txt"} {
+		if body := get(path); body != "primary "+path {
+			t.Error("expected recovered primary to be used, got", body)
+		}
+	}
+
+	if !o.isHealthy() {
+		t.Error("expected primary to be healthy again")
+	}
+
+	if n := atomic.LoadInt32(&primaryRequests); n != 3 {
+		t.Error("expected 3 requests to primary, got", n)
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	server, cleanup := setupTestServer(t, nil)
+	defer cleanup()
+
+	var failing int32 = 1
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "HEAD" || r.URL.Path != server.config.HealthCheckPath {
+			t.Error("unexpected health check", r.Method, r.URL.Path)
+		}
+
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		// missing paths still mean the origin is up
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer origin.Close()
+
+	o := newOrigins(origin.URL, nil)[0]
+
+	server.checkOrigin(http.DefaultClient, o)
+
+	if o.isHealthy() {
+		t.Error("expected origin responding with 502 to be unhealthy")
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	server.checkOrigin(http.DefaultClient, o)
+
+	if !o.isHealthy() {
+		t.Error("expected origin to recover")
+	}
+
+	origin.Close()
+	server.checkOrigin(http.DefaultClient, o)
+
+	if o.isHealthy() {
+		t.Error("expected unreachable origin to be unhealthy")
+	}
+}
+
+func TestCheckOriginsSkipsSingleOrigin(t *testing.T) {
+	server, cleanup := setupTestServer(t, nil)
+	defer cleanup()
+
+	var requests int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer origin.Close()
+
+	server.defaultRoute.origins = newOrigins(origin.URL, nil)
+	server.checkOrigins(http.DefaultClient)
+
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Error("expected route without fallbacks not to be checked, got", n, "requests")
+	}
+
+	server.defaultRoute.origins = newOrigins(origin.URL, []string{origin.URL})
+	server.checkOrigins(http.DefaultClient)
+
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Error("expected both origins to be checked, got", n, "requests")
+	}
+}
+
+func TestCandidateOrigins(t *testing.T) {
+	route := &originRoute{origins: newOrigins("http://a", []string{"http://b", "http://c"})}
+
+	route.origins[0].markFailed("down")
+	candidates := route.candidateOrigins()
+
+	if len(candidates) != 2 || candidates[0].baseURL != "http://b" {
+		t.Error("expected healthy origins in order")
+	}
+
+	for _, o := range route.origins {
+		o.markFailed("down")
+	}
+
+	if len(route.candidateOrigins()) != 3 {
+		t.Error("expected every origin to be tried when none are healthy")
+	}
+}
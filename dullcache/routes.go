@@ -0,0 +1,221 @@
+package dullcache
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Sends requests matching Host and PathPrefix to BaseURL instead of the
+// default origin. Empty Host or PathPrefix match everything. Paths cached for
+// a route are keyed as Name:/path so they can't collide with other routes
+type RouteConfig struct {
+	Name       string
+	Host       string
+	PathPrefix string
+	BaseURL    string
+
+	// Equivalent origins tried in order when BaseURL fails
+	FallbackURLs []string
+
+	// Remove PathPrefix from the path before requesting it from BaseURL
+	StripPrefix bool
+
+	// Optional credentials for signing requests to a GCS origin, as in Config
+	GoogleAccessID              string
+	GoogleStoragePrivateKeyPath string
+	SignedOriginBuckets         []string
+
+	// Optional credentials for signing requests to an S3 compatible origin, as
+	// in Config
+	AWSAccessKeyID     string
+	AWSSecretAccessKey string
+	AWSSessionToken    string
+	AWSRegion          string
+}
+
+// set on requests once the route serving them is known
+const routeKey contextKey = 2
+
+type originRoute struct {
+	name        string
+	host        string
+	pathPrefix  string
+	stripPrefix bool
+	origins     []*origin
+
+	// signs requests to the origins, nil if they're sent unsigned
+	signer originSigner
+	// checks the signatures of URLs sent by clients, nil if they aren't checked
+	urlSigner *urlSigner
+}
+
+// The route a request was matched to
+type routeMatch struct {
+	route *originRoute
+	// the path is stored in the cache under
+	key string
+	// path and escaped path and query relative to the base URL of the route.
+	// Both come from the URL so handlers like http.StripPrefix are respected
+	path       string
+	requestURI string
+}
+
+func newRoute(config RouteConfig) *originRoute {
+	route := &originRoute{
+		name:        config.Name,
+		host:        strings.ToLower(config.Host),
+		pathPrefix:  config.PathPrefix,
+		stripPrefix: config.StripPrefix,
+		origins:     newOrigins(config.BaseURL, config.FallbackURLs),
+	}
+
+	if config.GoogleAccessID != "" && config.GoogleStoragePrivateKeyPath != "" {
+		signer, err := NewURLSigner(config.GoogleAccessID, config.GoogleStoragePrivateKeyPath)
+		if err != nil {
+			log.Print("Warning: failed to create URL signer: ", err)
+		} else {
+			route.urlSigner = signer
+			route.signer = &gcsOriginSigner{signer: signer, buckets: config.SignedOriginBuckets}
+		}
+	}
+
+	if config.AWSAccessKeyID != "" {
+		route.signer = newSigV4Signer(config.AWSAccessKeyID, config.AWSSecretAccessKey,
+			config.AWSSessionToken, config.AWSRegion)
+	}
+
+	return route
+}
+
+// Builds the routes from the config. The default route uses the top level
+// origin settings and keeps paths as their cache keys
+func newRoutes(config *Config) ([]*originRoute, *originRoute, error) {
+	defaultRoute := newRoute(RouteConfig{
+		BaseURL:                     config.BaseURL,
+		FallbackURLs:                config.FallbackURLs,
+		GoogleAccessID:              config.GoogleAccessID,
+		GoogleStoragePrivateKeyPath: config.GoogleStoragePrivateKeyPath,
+		SignedOriginBuckets:         config.SignedOriginBuckets,
+		AWSAccessKeyID:              config.AWSAccessKeyID,
+		AWSSecretAccessKey:          config.AWSSecretAccessKey,
+		AWSSessionToken:             config.AWSSessionToken,
+		AWSRegion:                   config.AWSRegion,
+	})
+
+	var routes []*originRoute
+	names := make(map[string]bool)
+
+	for _, routeConfig := range config.Routes {
+		name := routeConfig.Name
+
+		if name == "" || strings.ContainsAny(name, ":/") {
+			return nil, nil, fmt.Errorf("invalid route name: %q", name)
+		}
+
+		if names[name] {
+			return nil, nil, fmt.Errorf("duplicate route name: %v", name)
+		}
+
+		if routeConfig.BaseURL == "" {
+			return nil, nil, fmt.Errorf("route %v needs a BaseURL", name)
+		}
+
+		names[name] = true
+		routes = append(routes, newRoute(routeConfig))
+	}
+
+	return routes, defaultRoute, nil
+}
+
+// Checks if the route serves requests for host and path
+func (route *originRoute) matches(host, path string) bool {
+	if route.host != "" && route.host != host {
+		return false
+	}
+
+	return strings.HasPrefix(path, route.pathPrefix)
+}
+
+// Returns the first route matching the request, or the default route
+func (server *Server) matchRoute(r *http.Request) *routeMatch {
+	host := strings.ToLower(r.Host)
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	route := server.defaultRoute
+
+	for _, candidate := range server.routes {
+		if candidate.matches(host, r.URL.Path) {
+			route = candidate
+			break
+		}
+	}
+
+	match := &routeMatch{
+		route:      route,
+		key:        r.URL.Path,
+		path:       r.URL.Path,
+		requestURI: r.URL.RequestURI(),
+	}
+
+	if route.name != "" {
+		match.key = route.name + ":" + r.URL.Path
+	}
+
+	if route.stripPrefix {
+		match.path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, route.pathPrefix), "/")
+
+		rawPrefix := (&url.URL{Path: route.pathPrefix}).EscapedPath()
+		match.requestURI = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.RequestURI(), rawPrefix), "/")
+	}
+
+	return match
+}
+
+// Returns the route the request was matched to by cacheHandler, matching it
+// now if it hasn't been
+func (server *Server) requestRoute(r *http.Request) *routeMatch {
+	if match, ok := r.Context().Value(routeKey).(*routeMatch); ok {
+		return match
+	}
+
+	return server.matchRoute(r)
+}
+
+// Returns the key the path requested by r is cached under
+func (server *Server) cacheKey(r *http.Request) string {
+	return server.requestRoute(r).key
+}
+
+// Returns a copy of r carrying its matched route in ctx
+func withRoute(ctx context.Context, r *http.Request, match *routeMatch) *http.Request {
+	return r.WithContext(context.WithValue(ctx, routeKey, match))
+}
+
+// Checks if requests to the backend for the path requested by r are signed by
+// dullcache instead of relying on the signature sent by the client
+func (server *Server) originSigned(r *http.Request) bool {
+	match := server.requestRoute(r)
+	return match.route.signer != nil && match.route.signer.SignsPath(match.path)
+}
+
+// Checks the signature the client sent for the path requested by r with the
+// URL signer of its route. Signatures are for the path on the backend
+func (server *Server) verifyRouteURL(r *http.Request) error {
+	match := server.requestRoute(r)
+
+	if match.route.urlSigner == nil {
+		return fmt.Errorf("no key to verify signatures")
+	}
+
+	checkURL := *r.URL
+	checkURL.Path = match.path
+
+	return match.route.urlSigner.VerifyURL(&checkURL)
+}
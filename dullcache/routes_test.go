@@ -0,0 +1,169 @@
+package dullcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewRoutes(t *testing.T) {
+	c := defaultConfig
+	c.Routes = []RouteConfig{
+		{Name: "assets", PathPrefix: "/assets/", BaseURL: "http://assets.example.com"},
+		{Name: "mirror", Host: "Mirror.Example.com", BaseURL: "http://mirror.example.com"},
+	}
+
+	routes, defaultRoute, err := newRoutes(&c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(routes) != 2 || routes[1].host != "mirror.example.com" {
+		t.Error("expected routes in config order with lowercased hosts")
+	}
+
+	if defaultRoute.name != "" || defaultRoute.origins[0].baseURL != c.BaseURL {
+		t.Error("expected default route for BaseURL, got", defaultRoute.origins[0].baseURL)
+	}
+
+	invalid := [][]RouteConfig{
+		{{Name: "", BaseURL: "http://a"}},
+		{{Name: "a:b", BaseURL: "http://a"}},
+		{{Name: "a/b", BaseURL: "http://a"}},
+		{{Name: "a"}},
+		{{Name: "a", BaseURL: "http://a"}, {Name: "a", BaseURL: "http://b"}},
+	}
+
+	for _, routeConfigs := range invalid {
+		c.Routes = routeConfigs
+
+		if _, _, err := newRoutes(&c); err == nil {
+			t.Error("expected invalid routes to fail", routeConfigs)
+		}
+	}
+}
+
+func TestMatchRoute(t *testing.T) {
+	server, cleanup := setupTestServer(t, nil)
+	defer cleanup()
+
+	c := *server.config
+	c.Routes = []RouteConfig{
+		{Name: "assets", Host: "cdn.example.com", PathPrefix: "/assets/",
+			StripPrefix: true, BaseURL: "http://assets.example.com"},
+		{Name: "mirror", Host: "mirror.example.com", BaseURL: "http://mirror.example.com"},
+		{Name: "builds", PathPrefix: "/builds/", BaseURL: "http://builds.example.com"},
+	}
+
+	routes, _, err := newRoutes(&c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.routes = routes
+
+	tests := []struct {
+		host       string
+		uri        string
+		key        string
+		requestURI string
+	}{
+		{"cdn.example.com", "/assets/app%20one.js", "assets:/assets/app one.js", "/app%20one.js"},
+		{"CDN.example.com:8080", "/assets/app.js?v=2", "assets:/assets/app.js", "/app.js?v=2"},
+		{"cdn.example.com", "/other/app.js", "/other/app.js", "/other/app.js"},
+		{"mirror.example.com", "/bucket/file.zip", "mirror:/bucket/file.zip", "/bucket/file.zip"},
+		{"other.example.com", "/builds/1.zip", "builds:/builds/1.zip", "/builds/1.zip"},
+		{"other.example.com", "/bucket/file.zip", "/bucket/file.zip", "/bucket/file.zip"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.uri, nil)
+		req.Host = test.host
+
+		match := server.matchRoute(req)
+
+		if match.key != test.key {
+			t.Errorf("expected key %v for %v%v, got %v", test.key, test.host, test.uri, match.key)
+		}
+
+		if match.requestURI != test.requestURI {
+			t.Errorf("expected request URI %v for %v%v, got %v", test.requestURI, test.host, test.uri, match.requestURI)
+		}
+	}
+}
+
+func TestRoutedOrigins(t *testing.T) {
+	server, cleanup := setupTestServer(t, nil)
+	defer cleanup()
+
+	defaultOrigin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("default " + r.URL.Path))
+	}))
+	defer defaultOrigin.Close()
+
+	mirrorOrigin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("mirror " + r.URL.Path))
+	}))
+	defer mirrorOrigin.Close()
+
+	server.defaultRoute.origins = newOrigins(defaultOrigin.URL, nil)
+	server.routes = []*originRoute{
+		newRoute(RouteConfig{Name: "mirror", Host: "mirror.example.com", BaseURL: mirrorOrigin.URL}),
+	}
+
+	get := func(host string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/bucket/file.txt", nil)
+		req.Host = host
+
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := get("cache.example.com"); w.Body.String() != "default /bucket/file.txt" {
+			t.Error("expected default origin, got", w.Body.String())
+		}
+
+		if w := get("mirror.example.com"); w.Body.String() != "mirror /bucket/file.txt" {
+			t.Error("expected mirror origin, got", w.Body.String())
+		}
+	}
+
+	if server.fileCache.PathEntry("/bucket/file.txt") == nil {
+		t.Error("expected default route path to be cached under its path")
+	}
+
+	if server.fileCache.PathEntry("mirror:/bucket/file.txt") == nil {
+		t.Error("expected mirror route path to be cached under its namespaced key")
+	}
+}
+
+func TestHandlerUnderStripPrefix(t *testing.T) {
+	server, cleanup := setupTestServer(t, nil)
+	defer cleanup()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("origin " + r.URL.RequestURI()))
+	}))
+	defer origin.Close()
+
+	server.defaultRoute.origins = newOrigins(origin.URL, nil)
+
+	// RequestURI keeps the prefix, like a request from a real client would
+	req, _ := http.NewRequest("GET", "/cdn/bucket/file%20name.txt?a=b", nil)
+	req.RequestURI = "/cdn/bucket/file%20name.txt?a=b"
+
+	w := httptest.NewRecorder()
+	http.StripPrefix("/cdn", server.Handler()).ServeHTTP(w, req)
+
+	if w.Body.String() != "origin /bucket/file%20name.txt?a=b" {
+		t.Error("expected origin to be asked for the stripped path, got", w.Body.String())
+	}
+
+	if server.fileCache.PathEntry("/bucket/file name.txt") == nil {
+		t.Error("expected stripped path to be cached")
+	}
+}
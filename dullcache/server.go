@@ -9,22 +9,72 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/cupcake/mannersagain"
 	"github.com/dustin/go-humanize"
 )
 
 type errorHandler func(http.ResponseWriter, *http.Request) error
-type adminHandler func(http.ResponseWriter, *http.Request) error
 
-var fileCache *FileCache
-var config *Config
-var headURLSigner *urlSigner
+// A dullcache instance built from a Config. Several servers can run in the
+// same process as long as they use different cache directories
+type Server struct {
+	config    *Config
+	fileCache *FileCache
+	stats     *serverStats
+	adminAuth *adminAuth
+	accessLog *accessLogger
+	client    *http.Client
+
+	// routes are matched in order, requests matching none use the default
+	routes       []*originRoute
+	defaultRoute *originRoute
+
+	handler      http.Handler
+	adminHandler http.Handler
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
 
 var headersToFilter = map[string]bool{"Accept-Ranges": true, "Server": true}
 
-var stats *serverStats
+// response header telling the client how the cache handled the request
+const cacheStatusHeader = "X-Cache"
+
+const (
+	cacheStatusHit            = "HIT"
+	cacheStatusCheckedHit     = "HIT-CHECKED"
+	cacheStatusCoalesced      = "HIT-COALESCED"
+	cacheStatusRevalidated    = "HIT-REVALIDATED"
+	cacheStatusStale          = "HIT-STALE"
+	cacheStatusUnverified     = "HIT-UNVERIFIED"
+	cacheStatusNegative       = "HIT-NEGATIVE"
+	cacheStatusStore          = "MISS"
+	cacheStatusPassBusy       = "PASS-BUSY"
+	cacheStatusPassUnverified = "PASS-UNVERIFIED"
+	cacheStatusPassRange      = "PASS-RANGE"
+)
+
+// prefix for AdminAddress to listen on a unix socket
+const adminUnixPrefix = "unix:"
+
+// how long to wait before trying to listen on AdminAddress again
+const adminListenRetry = time.Duration(5) * time.Second
+
+// timeout for requests to the backend
+const originTimeout = time.Duration(4) * time.Hour
+
+// how often the evictor checks the cache size against MaxCacheBytes
+const evictInterval = time.Minute
+
+// percent of MaxCacheBytes the evictor shrinks the cache down to
+const evictLowWaterPercent = 90
+
+// how often the available paths are written to the index in the cache dir
+const indexSaveInterval = time.Duration(30) * time.Second
 
 func calculateSpeedKbs(copied int64, elapsed time.Duration) int64 {
 	return int64(float64(copied) / float64(elapsed) * float64(time.Second) / 1024)
@@ -36,36 +86,62 @@ func (fn errorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (fn adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !authAdminRequest(r) {
-		http.Error(w, "Invalid request", 500)
-	}
+// Wraps an admin handler so it only runs for requests granted role. Handlers
+// that only read state need the read role, ones that delete or purge need the
+// write role
+func (server *Server) admin(role adminRole, fn errorHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !server.adminAuth.authorize(r, role) {
+			log.Print("Unauthorized admin request: ", r.URL.Path, " ", r.RemoteAddr)
+			http.Error(w, "Invalid request", http.StatusForbidden)
+			return
+		}
 
-	if err := fn(w, r); err != nil {
-		http.Error(w, err.Error(), 500)
-	}
+		fn.ServeHTTP(w, r)
+	})
 }
 
-func authAdminRequest(r *http.Request) bool {
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
+// headers from the client that are forwarded to the backend
+var headersToForward = []string{"Range", "If-Range"}
+
+// headers from a 304 response that replace the stored ones
+var headersToRevalidate = []string{"Cache-Control", "Date", "Etag", "Expires", "Last-Modified"}
+
+func (server *Server) openRemote(r *http.Request) (*http.Response, error) {
+	req, err := server.newRemoteRequest(r)
 
 	if err != nil {
-		return false
+		return nil, err
 	}
 
-	for _, allowed := range config.AdminAddresses {
-		if allowed == host {
-			return true
+	for _, k := range headersToForward {
+		if v := r.Header.Get(k); v != "" {
+			req.Header.Set(k, v)
 		}
 	}
 
-	return false
+	return server.fetchRemote(r, req)
+}
+
+// Creates the request to the backend of its route for the path requested by
+// r, with the same method. The URL is relative to the origins of the route
+func (server *Server) newRemoteRequest(r *http.Request) (*http.Request, error) {
+	return http.NewRequest(r.Method, server.requestRoute(r).requestURI, nil)
 }
 
-func openRemote(r *http.Request) (*http.Response, error) {
-	fetchUrl := config.BaseURL + r.RequestURI
-	log.Print("Remote GET: ", r.URL.Path)
-	return http.Get(fetchUrl)
+// Sends req to the origins of the route of r, recording the time to first byte.
+// It's signed if the path is signed by dullcache
+func (server *Server) fetchRemote(r *http.Request, req *http.Request) (*http.Response, error) {
+	log.Print("Remote ", req.Method, ": ", server.cacheKey(r))
+
+	start := time.Now()
+	res, err := server.doRemote(server.requestRoute(r).route, req, server.originSigned(r))
+
+	if record := requestAccessRecord(r); record != nil {
+		record.OriginTTFB = time.Since(start).Seconds()
+	}
+
+	return res, err
 }
 
 func filterHeaders(headers http.Header) http.Header {
@@ -80,21 +156,20 @@ func filterHeaders(headers http.Header) http.Header {
 	return filtered
 }
 
-func headPath(subPath string) (http.Header, error) {
-	headURL := config.BaseURL + subPath
-	if headURLSigner != nil {
-		bucket, name, err := headURLSigner.SplitBucketAndName(subPath)
-		if err == nil {
-			// TODO: this generates bad urls for names with symbols in them
-			headURL, err = headURLSigner.SignURL("HEAD", bucket, name)
-			if err != nil {
-				return nil, err
-			}
-		}
+// Sends a HEAD for the path requested by r to the origins of its route
+func (server *Server) headPath(r *http.Request) (http.Header, error) {
+	match := server.requestRoute(r)
+	route := match.route
+
+	log.Print("Remote HEAD: ", match.key)
+	req, err := http.NewRequest("HEAD", escapeObjectName(match.path), nil)
+	if err != nil {
+		return nil, err
 	}
 
-	log.Print("Remote HEAD: ", subPath)
-	res, err := http.Head(headURL)
+	// the client's signature isn't forwarded, sign with any configured
+	// credentials instead
+	res, err := server.doRemote(route, req, route.signer != nil)
 	if err != nil {
 		return nil, err
 	}
@@ -102,20 +177,36 @@ func headPath(subPath string) (http.Header, error) {
 	defer res.Body.Close()
 
 	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("failed to head file: %v", res.StatusCode)
+		return nil, &originStatusError{res.StatusCode}
 	}
 
 	return filterHeaders(res.Header), err
 }
 
+// An unexpected status from a HEAD to the backend
+type originStatusError struct {
+	status int
+}
+
+func (err *originStatusError) Error() string {
+	return fmt.Sprintf("failed to head file: %v", err.status)
+}
+
+// Checks if an error from the backend means it's failing or can't be reached,
+// rather than the path being missing
+func originFailed(err error) bool {
+	statusErr, ok := err.(*originStatusError)
+	return !ok || statusErr.status >= 500
+}
+
 func passHeaders(w http.ResponseWriter, headers http.Header) {
 	for k, v := range filterHeaders(headers) {
 		w.Header()[k] = v
 	}
 }
 
-func passThrough(w http.ResponseWriter, r *http.Request) error {
-	remoteRes, err := openRemote(r)
+func (server *Server) passThrough(w http.ResponseWriter, r *http.Request, cacheStatus string) error {
+	remoteRes, err := server.openRemote(r)
 
 	if err != nil {
 		return err
@@ -124,117 +215,278 @@ func passThrough(w http.ResponseWriter, r *http.Request) error {
 	defer remoteRes.Body.Close()
 
 	passHeaders(w, remoteRes.Header)
+	w.Header().Set(cacheStatusHeader, cacheStatus)
+	w.WriteHeader(remoteRes.StatusCode)
 
-	stats.incrActivePath(r.URL.Path, 1)
+	subPath := server.cacheKey(r)
+	server.stats.incrActivePath(subPath, 1)
 	copied, err := io.Copy(w, remoteRes.Body)
-	stats.incrActivePath(r.URL.Path, -1)
+	server.stats.incrActivePath(subPath, -1)
 
-	stats.incrBytesFetched(uint64(copied))
-	stats.incrBytesSent(uint64(copied))
+	server.stats.incrBytesFetched(uint64(copied))
+	server.stats.incrBytesSent(uint64(copied))
 
-	if err == nil {
-		stats.incrSizeDist(uint64(copied))
+	if err == nil && r.Method == "GET" {
+		server.stats.incrSizeDist(uint64(copied))
 	}
 
 	return nil
 }
 
-func serveAndStore(w http.ResponseWriter, r *http.Request) error {
-	subPath := r.URL.Path
-	remoteRes, err := openRemote(r)
+func (server *Server) serveAndStore(w http.ResponseWriter, r *http.Request) error {
+	remoteRes, err := server.openRemote(r)
 
 	if err != nil {
 		return err
 	}
 
+	return server.storeResponse(w, r, remoteRes)
+}
+
+// Sends a response from the backend to the client, storing it in the cache if
+// it was successful and nothing else is writing the path
+func (server *Server) storeResponse(w http.ResponseWriter, r *http.Request, remoteRes *http.Response) error {
+	subPath := server.cacheKey(r)
 	defer remoteRes.Body.Close()
 
-	if remoteRes.StatusCode != 200 {
-		passHeaders(w, remoteRes.Header)
-		stats.incrActivePath(subPath, 1)
-		_, err = io.Copy(w, remoteRes.Body)
-		stats.incrActivePath(subPath, -1)
-		return err
+	if server.negativeCacheable(remoteRes.StatusCode) {
+		return server.storeNegative(w, r, remoteRes)
 	}
 
-	if err != nil {
+	if remoteRes.StatusCode != 200 {
+		passHeaders(w, remoteRes.Header)
+		w.Header().Set(cacheStatusHeader, cacheStatusStore)
+		w.WriteHeader(remoteRes.StatusCode)
+		server.stats.incrActivePath(subPath, 1)
+		_, err := io.Copy(w, remoteRes.Body)
+		server.stats.incrActivePath(subPath, -1)
 		return err
 	}
 
+	var err error
 	var targetWriter io.Writer = w
+	var tee *fillTeeWriter
+	var file *os.File
+	cacheStatus := cacheStatusStore
 
-	writingCache := fileCache.MarkPathBusy(subPath)
+	writingCache := server.fileCache.MarkPathBusy(subPath)
 	needsPurge := false
 
 	if writingCache {
-		defer fileCache.MarkPathFree(subPath)
-		needsPurge = fileCache.PathNeedsPurge(subPath)
+		defer server.fileCache.MarkPathFree(subPath)
+		needsPurge = server.fileCache.PathNeedsPurge(subPath)
 
-		file, err := fileCache.PathWriter(subPath)
+		file, err = server.fileCache.PathWriter(subPath)
 
 		if err != nil {
 			return err
 		}
 
-		defer file.Close()
+		expected := remoteRes.ContentLength
+		fill := server.fileCache.startFill(subPath, file, filterHeaders(remoteRes.Header))
+
+		tee = &fillTeeWriter{
+			fill:   fill,
+			client: w,
+			// requests streaming from the fill need the rest of the file
+			keepFilling: func(written int64) bool {
+				return server.finishAbandonedFill(expected, written) ||
+					!fill.abandon(fmt.Errorf("client gone"))
+			},
+		}
 
-		targetWriter = io.MultiWriter(file, targetWriter)
+		targetWriter = tee
 		log.Print("Serve and store: ", subPath)
-		stats.incrStores(1)
+		server.stats.incrStores(1)
 	} else {
 		log.Print("Pass through (from store): ", subPath)
-		stats.incrPasses(1)
+		server.stats.incrPasses(1)
+		cacheStatus = cacheStatusPassBusy
 	}
 
 	passHeaders(w, remoteRes.Header)
+	w.Header().Set(cacheStatusHeader, cacheStatus)
 
-	stats.incrActivePath(subPath, 1)
+	server.stats.incrActivePath(subPath, 1)
 	start := time.Now()
 	copied, err := io.Copy(targetWriter, remoteRes.Body)
 	elapsed := time.Since(start)
-	stats.incrActivePath(subPath, -1)
+	server.stats.incrActivePath(subPath, -1)
 
 	log.Print("Transfered ", subPath, " ",
 		calculateSpeedKbs(copied, elapsed), " KB/s ", r.RemoteAddr)
 
-	stats.incrBytesFetched(uint64(copied))
-	stats.incrBytesSent(uint64(copied))
+	sent := copied
+	if tee != nil {
+		sent = tee.clientWritten
+	}
+
+	server.stats.incrBytesFetched(uint64(copied))
+	server.stats.incrBytesSent(uint64(sent))
 
 	if err == nil {
-		stats.incrSizeDist(uint64(copied))
+		server.stats.incrSizeDist(uint64(copied))
+	}
+
+	if tee != nil && tee.clientErr != nil && err == nil {
+		log.Print("Finished fill after client went away: ", subPath)
 	}
 
 	if err != nil {
 		log.Print("Aborted writing cache: ", subPath)
+		if writingCache {
+			server.fileCache.endFill(subPath, err)
+			server.fileCache.AbortPathWriter(file)
+		}
 		// can't render normal error handler because we already set headers, so do
 		// nothing
 		return nil
 	}
 
 	if writingCache {
-		fileCache.MarkPathAvailable(subPath, filterHeaders(remoteRes.Header))
-		fileCache.accessList.AccessPath(subPath)
+		err = server.fileCache.CommitPathWriter(subPath, file, remoteRes.ContentLength)
+
+		if err != nil {
+			server.fileCache.endFill(subPath, err)
+			log.Print("Failed to store cache: ", subPath, ": ", err)
+			return nil
+		}
+
+		server.fileCache.MarkPathAvailable(subPath, filterHeaders(remoteRes.Header))
+		server.fileCache.endFill(subPath, nil)
+		server.fileCache.accessList.AccessPath(subPath)
 		log.Print("Cache stored: ", subPath)
 		if needsPurge {
-			fileCache.ReleasePathPurge(subPath)
+			server.fileCache.ReleasePathPurge(subPath)
 		}
 	}
 
 	return nil
 }
 
-func serveCache(w http.ResponseWriter, r *http.Request, fileHeaders http.Header) error {
-	if headURLSigner != nil {
-		err := headURLSigner.VerifyURL(r.URL)
+// Checks if a cache fill should keep fetching from the backend after the client
+// that triggered it has gone away
+func (server *Server) finishAbandonedFill(expected, written int64) bool {
+	if !server.config.FinishAbandonedFills {
+		return false
+	}
 
-		if err != nil {
-			stats.incrPasses(1)
-			log.Print("Passing unverifiable URL: ", r.URL.Path)
-			return passThrough(w, r)
+	if server.config.AbandonedFillMaxBytes == 0 {
+		return true
+	}
+
+	return expected >= 0 && expected-written <= server.config.AbandonedFillMaxBytes
+}
+
+// Checks the signature of the request URL when signing is configured. Cached
+// files should only be served to verified requests. Paths in buckets signed by
+// dullcache are checked before anything is served by clientAuthorized
+func (server *Server) urlVerified(r *http.Request) bool {
+	if server.requestRoute(r).route.urlSigner == nil || server.originSigned(r) {
+		return true
+	}
+
+	return server.verifyRouteURL(r) == nil
+}
+
+// Checks if the client can access a path in a bucket signed by dullcache. Only
+// URLs signed with the configured key are allowed when client signatures are
+// required
+func (server *Server) clientAuthorized(r *http.Request) bool {
+	if !server.config.RequireClientSignatures || !server.originSigned(r) {
+		return true
+	}
+
+	return server.verifyRouteURL(r) == nil
+}
+
+// Streams the file being written by another request as it's filled. Falls back
+// to passing through to the backend if the fill fails before anything is sent
+func (server *Server) serveFill(w http.ResponseWriter, r *http.Request, fill *cacheFill) error {
+	subPath := server.cacheKey(r)
+
+	if !server.urlVerified(r) {
+		server.stats.incrPasses(1)
+		log.Print("Passing unverifiable URL: ", subPath)
+		return server.passThrough(w, r, cacheStatusPassUnverified)
+	}
+
+	reader, err := fill.newReader()
+
+	if err == nil {
+		defer reader.Close()
+		_, _, err = fill.waitData(0)
+	}
+
+	if err != nil {
+		log.Print("Pass through (failed fill): ", subPath)
+		server.stats.incrPasses(1)
+		return server.passThrough(w, r, cacheStatusPassBusy)
+	}
+
+	log.Print("From cache coalesced: ", subPath)
+	server.stats.incrCoalesced(1)
+
+	passHeaders(w, fill.headers)
+	w.Header().Set(cacheStatusHeader, cacheStatusCoalesced)
+
+	server.stats.incrActivePath(subPath, 1)
+	start := time.Now()
+	copied, err := io.Copy(w, reader)
+	elapsed := time.Since(start)
+	server.stats.incrActivePath(subPath, -1)
+
+	log.Print("Transfered ", subPath, " ",
+		calculateSpeedKbs(copied, elapsed), " KB/s ", r.RemoteAddr)
+
+	server.stats.incrBytesSent(uint64(copied))
+
+	if err != nil {
+		log.Print("Aborted coalesced transfer: ", subPath, ": ", err)
+		return nil
+	}
+
+	server.stats.incrSizeDist(uint64(copied))
+	return nil
+}
+
+// Seconds since the entry was fetched from the backend, for the Age header
+func entryAge(entry *CacheEntry) int64 {
+	age := int64(time.Since(entry.FetchedAt) / time.Second)
+
+	if age < 0 {
+		return 0
+	}
+
+	return age
+}
+
+func (server *Server) serveCache(w http.ResponseWriter, r *http.Request, entry *CacheEntry, cacheStatus string) error {
+	subPath := server.cacheKey(r)
+
+	if !server.urlVerified(r) {
+		server.stats.incrPasses(1)
+		log.Print("Passing unverifiable URL: ", subPath)
+		return server.passThrough(w, r, cacheStatusPassUnverified)
+	}
+
+	fileHeaders := entry.Headers
+
+	if requestNotModified(r, fileHeaders) {
+		log.Print("Not modified: ", subPath)
+		server.stats.incrNotModified(1)
+
+		w.Header().Set(cacheStatusHeader, cacheStatus)
+		if !entry.FetchedAt.IsZero() {
+			w.Header().Set("Age", strconv.FormatInt(entryAge(entry), 10))
 		}
+
+		writeNotModified(w, fileHeaders)
+		server.fileCache.accessList.AccessPath(subPath)
+		return nil
 	}
 
-	filePath, err := fileCache.CacheFilePath(r.URL.Path)
+	filePath, err := server.fileCache.CacheFilePath(subPath)
 
 	if err != nil {
 		return err
@@ -249,68 +501,247 @@ func serveCache(w http.ResponseWriter, r *http.Request, fileHeaders http.Header)
 	defer file.Close()
 
 	passHeaders(w, fileHeaders)
+	// ServeContent sets the length of the range being sent
+	w.Header().Del("Content-Length")
+	w.Header().Set(cacheStatusHeader, cacheStatus)
+
+	if !entry.FetchedAt.IsZero() {
+		w.Header().Set("Age", strconv.FormatInt(entryAge(entry), 10))
+	}
+
+	// used by ServeContent for If-Range dates, zero if missing
+	modTime, _ := http.ParseTime(fileHeaders.Get("Last-Modified"))
 
-	stats.incrActivePath(r.URL.Path, 1)
+	counter := &countingWriter{ResponseWriter: w}
+
+	server.stats.incrActivePath(subPath, 1)
 	start := time.Now()
-	copied, err := io.Copy(w, file)
+	http.ServeContent(counter, r, "", modTime, file)
 	elapsed := time.Since(start)
-	stats.incrActivePath(r.URL.Path, -1)
+	server.stats.incrActivePath(subPath, -1)
+
+	copied := counter.written
 
-	log.Print("Transfered ", r.URL.Path, " ",
+	log.Print("Transfered ", subPath, " ",
 		calculateSpeedKbs(copied, elapsed), " KB/s ", r.RemoteAddr)
 
-	stats.incrBytesSent(uint64(copied))
+	server.stats.incrBytesSent(uint64(copied))
 
-	if err == nil {
-		stats.incrSizeDist(uint64(copied))
-		fileCache.accessList.AccessPath(r.URL.Path)
+	if counter.err == nil {
+		if counter.status == http.StatusOK && r.Method == "GET" {
+			server.stats.incrSizeDist(uint64(copied))
+		}
+
+		server.fileCache.accessList.AccessPath(subPath)
 	}
 
 	return nil
 }
 
-func purgeHandler(w http.ResponseWriter, r *http.Request) error {
-	if !authAdminRequest(r) {
-		log.Print("Unauthorized purge attempt: ", r.URL.Path)
-		return fmt.Errorf("unauthorized")
+// Sends a conditional GET to the backend for the stale entry of the path
+// requested by r
+func (server *Server) fetchConditional(r *http.Request, entry *CacheEntry) (*http.Response, error) {
+	req, err := server.newRemoteRequest(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if etag := entry.Headers.Get("Etag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	if lastModified := entry.Headers.Get("Last-Modified"); lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	server.stats.incrRevalidations(1)
+	return server.fetchRemote(r, req)
+}
+
+// Refreshes the metadata of a stale entry from a 304 response, the file is
+// kept as is
+func (server *Server) markNotModified(subPath string, entry *CacheEntry, remoteRes *http.Response) *CacheEntry {
+	headers := http.Header{}
+	for k, v := range entry.Headers {
+		headers[k] = v
+	}
+
+	for _, k := range headersToRevalidate {
+		if v, found := remoteRes.Header[k]; found {
+			headers[k] = v
+		}
+	}
+
+	return server.fileCache.MarkPathAvailable(subPath, headers)
+}
+
+// Revalidates a stale entry with a conditional GET to the backend. The cached
+// file is served if it's unchanged, otherwise the new response is stored. The
+// stale file is served if the backend fails within the stale-if-error window
+func (server *Server) revalidate(w http.ResponseWriter, r *http.Request, entry *CacheEntry) error {
+	subPath := server.cacheKey(r)
+
+	if !server.urlVerified(r) {
+		server.stats.incrPasses(1)
+		log.Print("Passing unverifiable URL: ", subPath)
+		return server.passThrough(w, r, cacheStatusPassUnverified)
+	}
+
+	remoteRes, err := server.fetchConditional(r, entry)
+
+	if err == nil && remoteRes.StatusCode >= 500 {
+		remoteRes.Body.Close()
+		err = fmt.Errorf("failed to revalidate: %v", remoteRes.StatusCode)
+	}
+
+	if err != nil {
+		if !server.staleServable(entry, staleIfError, server.config.StaleIfError) {
+			return err
+		}
+
+		log.Print("From cache stale after error: ", subPath, ": ", err)
+		server.stats.incrStaleHits(1)
+		return server.serveCache(w, r, entry, cacheStatusStale)
+	}
+
+	if remoteRes.StatusCode == http.StatusNotModified {
+		remoteRes.Body.Close()
+		log.Print("From cache revalidated: ", subPath)
+		entry = server.markNotModified(subPath, entry, remoteRes)
+		return server.serveCache(w, r, entry, cacheStatusRevalidated)
+	}
+
+	// the whole file changed but the client only wants part of it, refetch on
+	// the next full request
+	if remoteRes.StatusCode == http.StatusOK && r.Header.Get("Range") != "" {
+		remoteRes.Body.Close()
+		server.fileCache.MarkPathNeedsPurge(subPath)
+		server.stats.incrPasses(1)
+		return server.passThrough(w, r, cacheStatusPassRange)
+	}
+
+	log.Print("Refetching changed path: ", subPath)
+	return server.storeResponse(w, r, remoteRes)
+}
+
+func (server *Server) purgeHandler(w http.ResponseWriter, r *http.Request) error {
+	subPath := server.cacheKey(r)
+	log.Print("Purging: ", subPath)
+	return server.purgePath(subPath)
+}
+
+// Answers a HEAD from the stored headers of a fresh path, otherwise with the
+// headers from a HEAD to the backend. The body is never fetched
+func (server *Server) headHandler(w http.ResponseWriter, r *http.Request) error {
+	subPath := server.cacheKey(r)
+
+	if !server.urlVerified(r) {
+		server.stats.incrPasses(1)
+		log.Print("Passing unverifiable URL: ", subPath)
+		return server.passThrough(w, r, cacheStatusPassUnverified)
+	}
+
+	entry := server.fileCache.PathEntry(subPath)
+
+	if entry != nil && !entry.Stale(time.Now()) && !server.fileCache.PathNeedsPurge(subPath) {
+		log.Print("Head from cache: ", subPath)
+		server.stats.incrHeadHits(1)
+		return server.serveCache(w, r, entry, cacheStatusHit)
+	}
+
+	server.stats.incrHeadMisses(1)
+	headers, err := server.headPath(r)
+
+	if statusErr, ok := err.(*originStatusError); ok {
+		w.Header().Set(cacheStatusHeader, cacheStatusStore)
+		w.WriteHeader(statusErr.status)
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if requestNotModified(r, headers) {
+		w.Header().Set(cacheStatusHeader, cacheStatusStore)
+		writeNotModified(w, headers)
+		return nil
 	}
 
-	log.Print("Purging: ", r.URL.Path)
-	fileCache.MarkPathNeedsPurge(r.URL.Path)
+	passHeaders(w, headers)
+	w.Header().Set(cacheStatusHeader, cacheStatusStore)
 	return nil
 }
 
-func cacheHandler(w http.ResponseWriter, r *http.Request) error {
+func (server *Server) cacheHandler(w http.ResponseWriter, r *http.Request) error {
+	r = withRoute(r.Context(), r, server.matchRoute(r))
+
 	if r.Method == "DELETE" {
-		return purgeHandler(w, r)
+		server.admin(adminRoleWrite, server.purgeHandler).ServeHTTP(w, r)
+		return nil
 	}
 
-	if r.Method != "GET" {
-		return fmt.Errorf("only GET allowed")
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return fmt.Errorf("only GET and HEAD allowed")
 	}
 
-	subPath := r.URL.Path
+	if r.URL.Path == "/" {
+		return nil
+	}
 
-	if subPath == "/" {
+	subPath := server.cacheKey(r)
+
+	if !server.clientAuthorized(r) {
+		log.Print("Rejecting unsigned URL: ", subPath)
+		http.Error(w, "invalid signature", http.StatusForbidden)
 		return nil
 	}
 
-	if !fileCache.PathNeedsPurge(subPath) {
-		availableHeaders := fileCache.PathAvailable(subPath)
-		if availableHeaders != nil {
+	if entry := server.fileCache.PathMissing(subPath); entry != nil && server.urlVerified(r) {
+		return server.serveNegative(w, r, entry)
+	}
+
+	if r.Method == "HEAD" {
+		return server.headHandler(w, r)
+	}
+
+	if !server.fileCache.PathNeedsPurge(subPath) {
+		entry := server.fileCache.PathEntry(subPath)
+
+		if entry != nil && !entry.Stale(time.Now()) {
 			log.Print("From cache quick: " + subPath)
-			stats.incrFastHits(1)
-			return serveCache(w, r, availableHeaders)
+			server.stats.incrFastHits(1)
+			return server.serveCache(w, r, entry, cacheStatusHit)
+		}
+
+		if entry != nil && server.staleServable(entry, staleWhileRevalidate, server.config.StaleWhileRevalidate) {
+			log.Print("From cache stale: " + subPath)
+			server.stats.incrStaleHits(1)
+			server.refreshInBackground(r)
+			return server.serveCache(w, r, entry, cacheStatusStale)
+		}
+
+		// stale paths being refetched are served from the fill below
+		if entry != nil && !server.fileCache.PathBusy(subPath) {
+			return server.revalidate(w, r, entry)
 		}
 
-		size, err := fileCache.PathMaybeAvailable(subPath)
+		size, err := server.fileCache.PathMaybeAvailable(subPath)
 
 		if err != nil {
 			return err
 		}
 
-		if size > 0 {
-			headers, err := headPath(subPath)
+		if entry == nil && size > 0 && server.config.StaleWhileRevalidate > 0 {
+			log.Print("From cache unverified: " + subPath)
+			server.stats.incrStaleHits(1)
+			server.refreshInBackground(r)
+			return server.serveUnverified(w, r, size)
+		}
+
+		if entry == nil && size > 0 {
+			headers, err := server.headPath(r)
 
 			if err == nil {
 				contentLenStr := headers.Get("Content-Length")
@@ -318,86 +749,128 @@ func cacheHandler(w http.ResponseWriter, r *http.Request) error {
 
 				if err == nil {
 					if int64(contentLen) == size {
-						fileCache.MarkPathAvailable(subPath, headers)
+						entry := server.fileCache.MarkPathAvailable(subPath, headers)
 						log.Print("From cache checked: ", subPath)
-						stats.incrCheckedHits(1)
-						return serveCache(w, r, headers)
+						server.stats.incrCheckedHits(1)
+						return server.serveCache(w, r, entry, cacheStatusCheckedHit)
 					}
 				}
+			} else if server.config.StaleIfError > 0 && originFailed(err) {
+				log.Print("From cache unverified after error: ", subPath, ": ", err)
+				server.stats.incrStaleHits(1)
+				return server.serveUnverified(w, r, size)
 			} else {
 				log.Print("Warning, failed to HEAD path: ", subPath)
 			}
 		}
 	}
 
-	if fileCache.PathBusy(subPath) {
+	if server.fileCache.PathBusy(subPath) {
+		fill := server.fileCache.pathFill(subPath)
+
+		if fill != nil && r.Header.Get("Range") == "" {
+			return server.serveFill(w, r, fill)
+		}
+
 		log.Print("Pass through: ", subPath)
-		stats.incrPasses(1)
-		return passThrough(w, r)
+		server.stats.incrPasses(1)
+		return server.passThrough(w, r, cacheStatusPassBusy)
+	}
+
+	// a partial response can't be stored as the whole file
+	if r.Header.Get("Range") != "" {
+		log.Print("Pass through range: ", subPath)
+		server.stats.incrPasses(1)
+		return server.passThrough(w, r, cacheStatusPassRange)
 	}
 
-	return serveAndStore(w, r)
+	return server.serveAndStore(w, r)
 }
 
-func statHandler(w http.ResponseWriter, r *http.Request) error {
+func (server *Server) statHandler(w http.ResponseWriter, r *http.Request) error {
 	if r.Method == "HEAD" {
 		return nil
 	}
 
-	stats.RLock()
-	defer stats.RUnlock()
-
-	fmt.Fprintln(w, "Available paths: ", fileCache.CountAvailablePaths())
-	fmt.Fprintln(w, "Busy paths: ", fileCache.CountBusyPaths())
-	fmt.Fprintln(w, "Purged paths: ", fileCache.CountPurgedPaths())
-	fmt.Fprintln(w, "Fast hits: ", stats.fastHits)
-	fmt.Fprintln(w, "Checked hits: ", stats.checkedHits)
-	fmt.Fprintln(w, "Passes: ", stats.passes)
-	fmt.Fprintln(w, "Stores: ", stats.stores)
-	fmt.Fprintln(w, "Active transfers: ", stats.countActivePaths())
+	server.stats.RLock()
+	defer server.stats.RUnlock()
+
+	fmt.Fprintln(w, "Available paths: ", server.fileCache.CountAvailablePaths())
+	fmt.Fprintln(w, "Busy paths: ", server.fileCache.CountBusyPaths())
+	fmt.Fprintln(w, "Purged paths: ", server.fileCache.CountPurgedPaths())
+	fmt.Fprintln(w, "Missing paths: ", server.fileCache.CountMissingPaths())
+	fmt.Fprintln(w, "Tags: ", server.fileCache.CountTags())
+	fmt.Fprintln(w, "Fast hits: ", server.stats.fastHits)
+	fmt.Fprintln(w, "Checked hits: ", server.stats.checkedHits)
+	fmt.Fprintln(w, "Coalesced hits: ", server.stats.coalesced)
+	fmt.Fprintln(w, "Revalidations: ", server.stats.revalidations)
+	fmt.Fprintln(w, "Stale hits: ", server.stats.staleHits)
+	fmt.Fprintln(w, "Not modified: ", server.stats.notModified)
+	fmt.Fprintln(w, "Negative hits: ", server.stats.negativeHits)
+	fmt.Fprintln(w, "Head hits: ", server.stats.headHits)
+	fmt.Fprintln(w, "Head misses: ", server.stats.headMisses)
+	fmt.Fprintln(w, "Passes: ", server.stats.passes)
+	fmt.Fprintln(w, "Stores: ", server.stats.stores)
+	fmt.Fprintln(w, "Evictions: ", server.stats.evictions)
+	fmt.Fprintln(w, "Active transfers: ", server.stats.countActivePaths())
 	fmt.Fprintln(w)
-	fmt.Fprintln(w, "Bytes fetched: ", humanize.Bytes(stats.bytesFetched))
-	fmt.Fprintln(w, "Bytes sent: ", humanize.Bytes(stats.bytesSent))
+	fmt.Fprintln(w, "Bytes fetched: ", humanize.Bytes(server.stats.bytesFetched))
+	fmt.Fprintln(w, "Bytes sent: ", humanize.Bytes(server.stats.bytesSent))
+	fmt.Fprintln(w, "Bytes evicted: ", humanize.Bytes(server.stats.bytesEvicted))
 
 	fmt.Fprintln(w)
 	fmt.Fprintln(w, "Size dist")
 	fmt.Fprintln(w, "=========")
 	for _, size := range sizeDistsMB {
-		fmt.Fprintln(w, size, "MB", stats.sizeDist[size])
+		fmt.Fprintln(w, size, "MB", server.stats.sizeDist[size])
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Origins")
+	fmt.Fprintln(w, "=======")
+	for _, route := range server.allRoutes() {
+		name := route.name
+		if name == "" {
+			name = "default"
+		}
+
+		for _, o := range route.origins {
+			fmt.Fprintln(w, name, o.baseURL, o.status())
+		}
 	}
 
 	return nil
 }
 
-func statActiveHandler(w http.ResponseWriter, r *http.Request) error {
-	stats.RLock()
-	defer stats.RUnlock()
-	for path, count := range stats.activePaths {
+func (server *Server) statActiveHandler(w http.ResponseWriter, r *http.Request) error {
+	server.stats.RLock()
+	defer server.stats.RUnlock()
+	for path, count := range server.stats.activePaths {
 		fmt.Fprintln(w, humanize.Comma(count), path)
 	}
 	return nil
 }
 
-func adminListHandler(w http.ResponseWriter, r *http.Request) error {
-	fileCache.availableMutex.RLock()
-	defer fileCache.availableMutex.RUnlock()
+func (server *Server) adminListHandler(w http.ResponseWriter, r *http.Request) error {
+	server.fileCache.availableMutex.RLock()
+	defer server.fileCache.availableMutex.RUnlock()
 
-	for path := range fileCache.availablePaths {
+	for path := range server.fileCache.availablePaths {
 		fmt.Fprintln(w, path)
 	}
 
 	return nil
 }
 
-func adminAccessListHandler(w http.ResponseWriter, r *http.Request) error {
-	fileCache.accessList.mutex.RLock()
-	defer fileCache.accessList.mutex.RUnlock()
+func (server *Server) adminAccessListHandler(w http.ResponseWriter, r *http.Request) error {
+	server.fileCache.accessList.mutex.RLock()
+	defer server.fileCache.accessList.mutex.RUnlock()
 
-	iter := fileCache.accessList.ordered.Iterator()
+	iter := server.fileCache.accessList.ordered.Iterator()
 
 	for iter.Next() {
 		path := iter.Key().(string)
-		time := fileCache.accessList.pathTimes[path]
+		time := server.fileCache.accessList.pathTimes[path]
 
 		fmt.Fprintf(w, "%v %v\n", time, path)
 	}
@@ -405,12 +878,12 @@ func adminAccessListHandler(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-func adminFileListHandler(w http.ResponseWriter, r *http.Request) error {
-	fileCache.availableMutex.RLock()
-	defer fileCache.availableMutex.RUnlock()
+func (server *Server) adminFileListHandler(w http.ResponseWriter, r *http.Request) error {
+	server.fileCache.availableMutex.RLock()
+	defer server.fileCache.availableMutex.RUnlock()
 
-	for path := range fileCache.availablePaths {
-		fname, err := fileCache.CacheFilePath(path)
+	for path := range server.fileCache.availablePaths {
+		fname, err := server.fileCache.CacheFilePath(path)
 		if err != nil {
 			return err
 		}
@@ -421,23 +894,23 @@ func adminFileListHandler(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-func adminStatPath(w http.ResponseWriter, r *http.Request) error {
+func (server *Server) adminStatPath(w http.ResponseWriter, r *http.Request) error {
 	values := r.URL.Query()
 	path := values.Get("path")
 	if path == "" {
 		return fmt.Errorf("missing path to stat")
 	}
 
-	fileCache.availableMutex.RLock()
-	defer fileCache.availableMutex.RUnlock()
+	server.fileCache.availableMutex.RLock()
+	defer server.fileCache.availableMutex.RUnlock()
 
-	headers, found := fileCache.availablePaths[path]
+	entry, found := server.fileCache.availablePaths[path]
 
 	if !found {
 		return fmt.Errorf("path is not available")
 	}
 
-	out, err := json.MarshalIndent(headers, "", "  ")
+	out, err := json.MarshalIndent(entry.Headers, "", "  ")
 
 	if err != nil {
 		return err
@@ -448,7 +921,7 @@ func adminStatPath(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-func adminDeletePath(w http.ResponseWriter, r *http.Request) error {
+func (server *Server) adminDeletePath(w http.ResponseWriter, r *http.Request) error {
 	values := r.URL.Query()
 	path := values.Get("path")
 	if path == "" {
@@ -457,40 +930,336 @@ func adminDeletePath(w http.ResponseWriter, r *http.Request) error {
 
 	log.Print("Delete", path)
 
-	return fileCache.DeletePath(path)
+	return server.fileCache.DeletePath(path)
+}
+
+// Purges or deletes every path matching the prefix, glob or regex given in the
+// query. With dry_run set the matches are listed without changing anything
+func (server *Server) adminMatchingPaths(w http.ResponseWriter, r *http.Request, action string, fn func(string) error) error {
+	match, err := requestPathMatcher(r.URL.Query())
+
+	if err != nil {
+		return err
+	}
+
+	paths, err := server.fileCache.MatchPaths(match)
+
+	if err != nil {
+		return err
+	}
+
+	return server.adminApplyPaths(w, r, paths, action, fn)
+}
+
+// Purges or deletes every path tagged with the surrogate key given in the
+// query. With dry_run set the tagged paths are listed without changing anything
+func (server *Server) adminTaggedPaths(w http.ResponseWriter, r *http.Request, action string, fn func(string) error) error {
+	tag := r.URL.Query().Get("tag")
+	if tag == "" {
+		return fmt.Errorf("missing tag")
+	}
+
+	return server.adminApplyPaths(w, r, server.fileCache.TaggedPaths(tag), action, fn)
+}
+
+// Runs fn on every path and reports how many it succeeded for, or just lists
+// the paths if the request is a dry run
+func (server *Server) adminApplyPaths(w http.ResponseWriter, r *http.Request, paths []string, action string, fn func(string) error) error {
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+	fmt.Fprintln(w, "Matched: ", len(paths))
+
+	if dryRun {
+		for _, path := range paths {
+			fmt.Fprintln(w, path)
+		}
+
+		return nil
+	}
+
+	count := 0
+
+	for _, path := range paths {
+		log.Print(action, ": ", path)
+		err := fn(path)
+
+		if err != nil {
+			log.Print("Failed to ", strings.ToLower(action), ": ", path, ": ", err)
+			continue
+		}
+
+		count += 1
+	}
+
+	fmt.Fprintln(w, action+": ", count)
+	return nil
 }
 
-func adminAvailableSize(w http.ResponseWriter, r *http.Request) error {
-	fmt.Fprintln(w, fileCache.TrackedSize())
+// Refetches path on the next request. A path that was only negatively cached
+// is just forgotten
+func (server *Server) purgePath(path string) error {
+	missing := server.fileCache.ReleasePathMissing(path)
+
+	if !missing || server.fileCache.PathEntry(path) != nil {
+		server.fileCache.MarkPathNeedsPurge(path)
+	}
+
 	return nil
 }
 
-func StartDullCache(_config *Config) error {
-	fileCache = NewFileCache("cache")
-	config = _config
-	if config.GoogleAccessID != "" && config.GoogleStoragePrivateKeyPath != "" {
-		signer, err := NewURLSigner(config.GoogleAccessID, config.GoogleStoragePrivateKeyPath)
+func (server *Server) adminPurgePaths(w http.ResponseWriter, r *http.Request) error {
+	return server.adminMatchingPaths(w, r, "Purged", server.purgePath)
+}
+
+func (server *Server) adminDeletePaths(w http.ResponseWriter, r *http.Request) error {
+	return server.adminMatchingPaths(w, r, "Deleted", server.fileCache.DeletePath)
+}
+
+func (server *Server) adminPurgeTag(w http.ResponseWriter, r *http.Request) error {
+	return server.adminTaggedPaths(w, r, "Purged", server.purgePath)
+}
+
+func (server *Server) adminDeleteTag(w http.ResponseWriter, r *http.Request) error {
+	return server.adminTaggedPaths(w, r, "Deleted", server.fileCache.DeletePath)
+}
+
+func (server *Server) adminAvailableSize(w http.ResponseWriter, r *http.Request) error {
+	fmt.Fprintln(w, server.fileCache.TrackedSize())
+	return nil
+}
+
+// Periodically evicts the least recently accessed paths once the cache goes
+// over maxBytes
+func (server *Server) runEvictor(maxBytes int64) {
+	lowWater := maxBytes / 100 * evictLowWaterPercent
+	ticker := time.NewTicker(evictInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-server.done:
+			return
+		}
+
+		if server.fileCache.TrackedSize() <= maxBytes {
+			continue
+		}
+
+		evicted, evictedBytes := server.fileCache.EvictToSize(lowWater)
+
+		for _, path := range evicted {
+			log.Print("Evicted: ", path)
+		}
+
+		server.stats.incrEvictions(uint64(len(evicted)))
+		server.stats.incrBytesEvicted(uint64(evictedBytes))
+	}
+}
+
+// Periodically writes the available paths to the index in the cache dir
+func (server *Server) runIndexSaver() {
+	ticker := time.NewTicker(indexSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-server.done:
+			return
+		}
+
+		err := server.fileCache.SaveIndex()
+
+		if err != nil {
+			log.Print("Warning: failed to save index: ", err)
+		}
+	}
+}
+
+// Registers the stat and admin routes
+func (server *Server) registerAdminRoutes(mux *http.ServeMux) {
+	mux.Handle("/stat/active", server.admin(adminRoleRead, server.statActiveHandler))
+	mux.Handle("/stat", server.admin(adminRoleRead, server.statHandler))
+	mux.Handle("/metrics", server.admin(adminRoleRead, server.metricsHandler))
+
+	mux.Handle("/admin/list/paths", server.admin(adminRoleRead, server.adminListHandler))
+	mux.Handle("/admin/list/access-times", server.admin(adminRoleRead, server.adminAccessListHandler))
+	mux.Handle("/admin/list/fnames", server.admin(adminRoleRead, server.adminFileListHandler))
+
+	mux.Handle("/admin/path-headers", server.admin(adminRoleRead, server.adminStatPath))
+	mux.Handle("/admin/delete-path", server.admin(adminRoleWrite, server.adminDeletePath))
+	mux.Handle("/admin/purge-paths", server.admin(adminRoleWrite, server.adminPurgePaths))
+	mux.Handle("/admin/delete-paths", server.admin(adminRoleWrite, server.adminDeletePaths))
+	mux.Handle("/admin/purge-tag", server.admin(adminRoleWrite, server.adminPurgeTag))
+	mux.Handle("/admin/delete-tag", server.admin(adminRoleWrite, server.adminDeleteTag))
+	mux.Handle("/admin/available-size", server.admin(adminRoleRead, server.adminAvailableSize))
+}
+
+// Serves the admin routes on their own listener. Addresses starting with
+// unix: are unix socket paths. Listening is retried so a new process can take
+// over the address once the one it's replacing exits
+func listenAdmin(address string, handler http.Handler) {
+	network := "tcp"
+
+	if strings.HasPrefix(address, adminUnixPrefix) {
+		network = "unix"
+		address = strings.TrimPrefix(address, adminUnixPrefix)
+		handler = adminSocketHandler(handler)
+	}
+
+	for {
+		if network == "unix" {
+			// remove the socket left by a previous process
+			os.Remove(address)
+		}
+
+		listener, err := net.Listen(network, address)
+
+		if err == nil {
+			log.Print("Admin listening on ", listener.Addr())
+			err = http.Serve(listener, handler)
+		}
+
+		log.Print("Warning: admin listener failed: ", err)
+		time.Sleep(adminListenRetry)
+	}
+}
+
+// Creates a server from config, restoring any cache state found in its cache
+// directory and starting the background evictor and index saver
+func NewServer(config *Config) (*Server, error) {
+	server := &Server{
+		config:    config,
+		fileCache: NewFileCache(config.CacheDir),
+		stats:     newServerStats(),
+		client:    &http.Client{Timeout: originTimeout, Transport: newOriginTransport()},
+		done:      make(chan struct{}),
+	}
+
+	auth, err := newAdminAuth(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	server.adminAuth = auth
+	server.routes, server.defaultRoute, err = newRoutes(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if config.AccessLogPath != "" {
+		server.accessLog, err = newAccessLogger(config.AccessLogPath, config.AccessLogFormat)
+
 		if err != nil {
-			log.Print("Warning: failed to create URL signer: ", err)
+			return nil, err
 		}
-		headURLSigner = signer
 	}
 
-	stats = newServerStats()
+	server.fileCache.SetTagHeader(config.SurrogateKeyHeader)
+
+	for prefix, seconds := range config.DefaultTTLs {
+		server.fileCache.SetDefaultTTL(prefix, time.Duration(seconds)*time.Second)
+	}
+
+	removed, err := server.fileCache.RemoveTempFiles()
+
+	if err != nil {
+		log.Print("Warning: failed to remove temp files: ", err)
+	} else if removed > 0 {
+		log.Print("Removed ", removed, " abandoned temp files")
+	}
+
+	loaded, err := server.fileCache.LoadIndex()
+
+	if err != nil {
+		log.Print("Warning: failed to load index: ", err)
+	} else {
+		log.Print("Loaded ", loaded, " paths from index")
+	}
+
+	contentMux := http.NewServeMux()
+	contentMux.Handle("/", errorHandler(server.cacheHandler))
+
+	adminMux := contentMux
+	if config.AdminAddress != "" {
+		adminMux = http.NewServeMux()
+	}
+
+	server.registerAdminRoutes(adminMux)
+
+	server.handler = contentMux
+	server.adminHandler = adminMux
+
+	if server.accessLog != nil {
+		server.handler = accessLogHandler(server.accessLog, server.handler)
+		server.adminHandler = accessLogHandler(server.accessLog, server.adminHandler)
+	}
+
+	go server.runIndexSaver()
+
+	if config.MaxCacheBytes > 0 {
+		go server.runEvictor(config.MaxCacheBytes)
+	}
+
+	if config.HealthCheckInterval > 0 && server.hasFallbackOrigins() {
+		go server.runHealthChecker(time.Duration(config.HealthCheckInterval) * time.Second)
+	}
+
+	return server, nil
+}
 
-	http.DefaultClient.Timeout = time.Duration(4) * time.Hour
+// Returns the handler for cached content. When no AdminAddress is configured
+// it also serves the stat and admin routes
+func (server *Server) Handler() http.Handler {
+	return server.handler
+}
 
-	http.Handle("/stat/active", errorHandler(statActiveHandler))
-	http.Handle("/stat", errorHandler(statHandler))
-	http.Handle("/", errorHandler(cacheHandler))
+// Returns the handler for the stat and admin routes
+func (server *Server) AdminHandler() http.Handler {
+	return server.adminHandler
+}
 
-	http.Handle("/admin/list/paths", adminHandler(adminListHandler))
-	http.Handle("/admin/list/access-times", adminHandler(adminAccessListHandler))
-	http.Handle("/admin/list/fnames", adminHandler(adminFileListHandler))
+// Opens the access log file again after it has been rotated
+func (server *Server) ReopenAccessLog() error {
+	if server.accessLog == nil {
+		return nil
+	}
 
-	http.Handle("/admin/path-headers", adminHandler(adminStatPath))
-	http.Handle("/admin/delete-path", adminHandler(adminDeletePath))
-	http.Handle("/admin/available-size", adminHandler(adminAvailableSize))
+	return server.accessLog.Reopen()
+}
+
+// Stops the background work of the server and saves the index. Transfers in
+// progress are not interrupted
+func (server *Server) Close() error {
+	var err error
+
+	server.closeOnce.Do(func() {
+		close(server.done)
+		err = server.fileCache.SaveIndex()
+
+		if server.accessLog != nil {
+			if closeErr := server.accessLog.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	})
+
+	return err
+}
+
+func StartDullCache(config *Config) error {
+	server, err := NewServer(config)
+
+	if err != nil {
+		return err
+	}
+
+	if config.AdminAddress != "" {
+		go listenAdmin(config.AdminAddress, server.AdminHandler())
+	}
 
-	return mannersagain.ListenAndServe(config.Address, nil)
+	return server.listenAndServe()
 }
@@ -0,0 +1,782 @@
+package dullcache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Creates a server for a temporary cache dir with content already stored for
+// the given paths
+func setupTestServer(t *testing.T, contents map[string]string) (*Server, func()) {
+	dir, err := ioutil.TempDir("", "dullcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := defaultConfig
+	c.CacheDir = dir
+
+	server, err := NewServer(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, body := range contents {
+		file, err := server.fileCache.PathWriter(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		file.Write([]byte(body))
+
+		if err := server.fileCache.CommitPathWriter(path, file, int64(len(body))); err != nil {
+			t.Fatal(err)
+		}
+
+		server.fileCache.MarkPathAvailable(path, http.Header{
+			"Content-Type":   []string{"text/plain"},
+			"Content-Length": []string{strconv.Itoa(len(body))},
+			"Last-Modified":  []string{"Mon, 02 Jan 2006 15:04:05 GMT"},
+			"Etag":           []string{`"abc"`},
+		})
+	}
+
+	return server, func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestServeCacheRange(t *testing.T) {
+	server, cleanup := setupTestServer(t, map[string]string{
+		"/bucket/file.txt": "0123456789",
+	})
+	defer cleanup()
+
+	req, _ := http.NewRequest("GET", "/bucket/file.txt", nil)
+	req.Header.Set("Range", "bytes=2-4")
+
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatal("expected 206, got", w.Code)
+	}
+
+	if w.Body.String() != "234" {
+		t.Error("expected range body, got", w.Body.String())
+	}
+
+	if w.Header().Get("Content-Range") != "bytes 2-4/10" {
+		t.Error("unexpected Content-Range", w.Header().Get("Content-Range"))
+	}
+
+	if w.Header().Get("Accept-Ranges") != "bytes" {
+		t.Error("expected Accept-Ranges to be advertised")
+	}
+
+	if w.Header().Get("X-Cache") != "HIT" {
+		t.Error("expected X-Cache HIT, got", w.Header().Get("X-Cache"))
+	}
+
+	if w.Header().Get("Age") != "0" {
+		t.Error("expected Age 0, got", w.Header().Get("Age"))
+	}
+}
+
+func TestServeCacheIfRange(t *testing.T) {
+	server, cleanup := setupTestServer(t, map[string]string{
+		"/bucket/file.txt": "0123456789",
+	})
+	defer cleanup()
+
+	req, _ := http.NewRequest("GET", "/bucket/file.txt", nil)
+	req.Header.Set("Range", "bytes=2-4")
+	req.Header.Set("If-Range", `"old"`)
+
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789" {
+		t.Error("expected full body for mismatched If-Range, got", w.Code, w.Body.String())
+	}
+}
+
+func TestRangeMissPassesThrough(t *testing.T) {
+	server, cleanup := setupTestServer(t, nil)
+	defer cleanup()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "bytes=0-1" {
+			t.Error("expected range to be forwarded to origin")
+		}
+
+		w.Header().Set("Content-Range", "bytes 0-1/10")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte("01"))
+	}))
+	defer origin.Close()
+
+	server.defaultRoute.origins = newOrigins(origin.URL, nil)
+
+	req, _ := http.NewRequest("GET", "/bucket/file.txt", nil)
+	req.Header.Set("Range", "bytes=0-1")
+
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusPartialContent || w.Body.String() != "01" {
+		t.Error("expected partial response from origin, got", w.Code, w.Body.String())
+	}
+
+	if w.Header().Get("X-Cache") != "PASS-RANGE" {
+		t.Error("expected X-Cache PASS-RANGE, got", w.Header().Get("X-Cache"))
+	}
+
+	if server.fileCache.PathAvailable("/bucket/file.txt") != nil {
+		t.Error("partial response should not be stored")
+	}
+}
+
+func TestCoalescedFill(t *testing.T) {
+	server, cleanup := setupTestServer(t, nil)
+	defer cleanup()
+
+	firstHalf := make(chan bool)
+	release := make(chan bool)
+	originRequests := 0
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		originRequests += 1
+		w.Header().Set("Content-Length", "10")
+		w.Write([]byte("01234"))
+		w.(http.Flusher).Flush()
+		firstHalf <- true
+		<-release
+		w.Write([]byte("56789"))
+	}))
+	defer origin.Close()
+
+	server.defaultRoute.origins = newOrigins(origin.URL, nil)
+
+	newRequest := func() *http.Request {
+		req, _ := http.NewRequest("GET", "/bucket/file.txt", nil)
+		return req
+	}
+
+	leader := httptest.NewRecorder()
+	leaderDone := make(chan bool)
+
+	go func() {
+		server.Handler().ServeHTTP(leader, newRequest())
+		leaderDone <- true
+	}()
+
+	<-firstHalf
+
+	// wait for the leader to write the first half into the cache
+	for {
+		fill := server.fileCache.pathFill("/bucket/file.txt")
+		if fill != nil {
+			if written, _, _ := fill.waitData(4); written == 5 {
+				break
+			}
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	follower := httptest.NewRecorder()
+	followerDone := make(chan bool)
+
+	go func() {
+		server.Handler().ServeHTTP(follower, newRequest())
+		followerDone <- true
+	}()
+
+	// don't let the leader finish until the follower has attached
+	for atomic.LoadUint64(&server.stats.coalesced) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	close(release)
+	<-leaderDone
+	<-followerDone
+
+	if originRequests != 1 {
+		t.Error("expected a single origin request, got", originRequests)
+	}
+
+	if follower.Body.String() != "0123456789" {
+		t.Error("expected follower to get the full body, got", follower.Body.String())
+	}
+
+	if leader.Header().Get("X-Cache") != "MISS" {
+		t.Error("expected leader X-Cache MISS, got", leader.Header().Get("X-Cache"))
+	}
+
+	if follower.Header().Get("X-Cache") != "HIT-COALESCED" {
+		t.Error("expected follower X-Cache HIT-COALESCED, got", follower.Header().Get("X-Cache"))
+	}
+
+}
+
+// Fails every write after the first, like a client that disconnects
+type disconnectingRecorder struct {
+	*httptest.ResponseRecorder
+	writes int
+}
+
+func (w *disconnectingRecorder) Write(p []byte) (int, error) {
+	w.writes += 1
+
+	if w.writes > 1 {
+		return 0, fmt.Errorf("client went away")
+	}
+
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestCoalescedFillLeaderGone(t *testing.T) {
+	server, cleanup := setupTestServer(t, nil)
+	defer cleanup()
+
+	firstChunk := make(chan bool)
+	release := make(chan bool)
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "15")
+		w.Write([]byte("01234"))
+		w.(http.Flusher).Flush()
+		firstChunk <- true
+		<-release
+		w.Write([]byte("56789"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("abcde"))
+	}))
+	defer origin.Close()
+
+	server.defaultRoute.origins = newOrigins(origin.URL, nil)
+
+	newRequest := func() *http.Request {
+		req, _ := http.NewRequest("GET", "/bucket/file.txt", nil)
+		return req
+	}
+
+	leader := &disconnectingRecorder{ResponseRecorder: httptest.NewRecorder()}
+	leaderDone := make(chan bool)
+
+	go func() {
+		server.Handler().ServeHTTP(leader, newRequest())
+		leaderDone <- true
+	}()
+
+	<-firstChunk
+
+	for {
+		fill := server.fileCache.pathFill("/bucket/file.txt")
+		if fill != nil {
+			if written, _, _ := fill.waitData(4); written == 5 {
+				break
+			}
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	follower := httptest.NewRecorder()
+	followerDone := make(chan bool)
+
+	go func() {
+		server.Handler().ServeHTTP(follower, newRequest())
+		followerDone <- true
+	}()
+
+	for atomic.LoadUint64(&server.stats.coalesced) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	close(release)
+	<-leaderDone
+	<-followerDone
+
+	if follower.Body.String() != "0123456789abcde" {
+		t.Error("expected follower to get the full body, got", follower.Body.String())
+	}
+
+	if server.fileCache.PathEntry("/bucket/file.txt") == nil {
+		t.Error("expected the fill to be stored after the leader went away")
+	}
+}
+
+func TestCacheAge(t *testing.T) {
+	server, cleanup := setupTestServer(t, map[string]string{
+		"/bucket/file.txt": "0123456789",
+	})
+	defer cleanup()
+
+	entry := server.fileCache.PathEntry("/bucket/file.txt")
+	server.fileCache.MarkPathAvailableAt("/bucket/file.txt", entry.Headers,
+		time.Now().Add(-time.Minute))
+
+	req, _ := http.NewRequest("GET", "/bucket/file.txt", nil)
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+
+	if w.Header().Get("Age") != "60" {
+		t.Error("expected Age 60, got", w.Header().Get("Age"))
+	}
+}
+
+func TestSignedOriginGet(t *testing.T) {
+	server, cleanup := setupTestServer(t, nil)
+	defer cleanup()
+
+	signer := getSigner(t)
+	server.defaultRoute.urlSigner = signer
+	server.defaultRoute.signer = &gcsOriginSigner{signer: signer, buckets: []string{"private"}}
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := signer.VerifyURL(r.URL); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		w.Write([]byte("secret"))
+	}))
+	defer origin.Close()
+
+	server.defaultRoute.origins = newOrigins(origin.URL, nil)
+
+	req, _ := http.NewRequest("GET", "/private/file name.txt", nil)
+
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Body.String() != "secret" {
+		t.Error("expected signed origin GET to succeed, got", w.Code, w.Body.String())
+	}
+
+	server.config.RequireClientSignatures = true
+
+	w = httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Error("expected unsigned request to be rejected, got", w.Code)
+	}
+
+	signed, _ := signer.SignURL("GET", "private", "file name.txt")
+	req, _ = http.NewRequest("GET", signed, nil)
+
+	w = httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Body.String() != "secret" {
+		t.Error("expected signed request to be served from cache, got", w.Code, w.Body.String())
+	}
+
+	if w.Header().Get("X-Cache") != "HIT" {
+		t.Error("expected X-Cache HIT, got", w.Header().Get("X-Cache"))
+	}
+}
+
+func TestSeparateAdminHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dullcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	originRequests := 0
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		originRequests += 1
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer origin.Close()
+
+	c := defaultConfig
+	c.CacheDir = dir
+	c.BaseURL = origin.URL
+	c.AdminAddress = "127.0.0.1:0"
+
+	server, err := NewServer(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Close()
+
+	for _, path := range []string{"/stat", "/metrics", "/admin/available-size"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+
+		w := httptest.NewRecorder()
+		server.AdminHandler().ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Error("expected admin handler to serve", path, "got", w.Code)
+		}
+
+		w = httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Error("expected content handler to pass", path, "to origin, got", w.Code)
+		}
+	}
+
+	if originRequests != 3 {
+		t.Error("expected 3 origin requests, got", originRequests)
+	}
+}
+
+func TestIndependentServers(t *testing.T) {
+	first, cleanupFirst := setupTestServer(t, map[string]string{
+		"/bucket/file.txt": "0123456789",
+	})
+	defer cleanupFirst()
+
+	second, cleanupSecond := setupTestServer(t, nil)
+	defer cleanupSecond()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("from origin"))
+	}))
+	defer origin.Close()
+
+	second.defaultRoute.origins = newOrigins(origin.URL, nil)
+
+	req, _ := http.NewRequest("GET", "/bucket/file.txt", nil)
+
+	w := httptest.NewRecorder()
+	first.Handler().ServeHTTP(w, req)
+
+	if w.Body.String() != "0123456789" || w.Header().Get("X-Cache") != "HIT" {
+		t.Error("expected first server to hit its cache, got", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	second.Handler().ServeHTTP(w, req)
+
+	if w.Body.String() != "from origin" || w.Header().Get("X-Cache") != "MISS" {
+		t.Error("expected second server to miss, got", w.Body.String())
+	}
+
+	if first.stats.fastHits != 1 || second.stats.fastHits != 0 {
+		t.Error("expected stats to be tracked per server")
+	}
+}
+
+// Marks a path stored by setupTestServer as fetched two minutes ago with a one
+// minute max-age
+func makePathStale(server *Server, path string) {
+	headers := http.Header{}
+	for k, v := range server.fileCache.PathEntry(path).Headers {
+		headers[k] = v
+	}
+
+	headers.Set("Cache-Control", "max-age=60")
+	server.fileCache.MarkPathAvailableAt(path, headers, time.Now().Add(-2*time.Minute))
+}
+
+func TestRevalidateNotModified(t *testing.T) {
+	server, cleanup := setupTestServer(t, map[string]string{
+		"/bucket/file.txt": "0123456789",
+	})
+	defer cleanup()
+
+	makePathStale(server, "/bucket/file.txt")
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != `"abc"` {
+			t.Error("expected If-None-Match, got", r.Header.Get("If-None-Match"))
+		}
+
+		if r.Header.Get("If-Modified-Since") != "Mon, 02 Jan 2006 15:04:05 GMT" {
+			t.Error("expected If-Modified-Since, got", r.Header.Get("If-Modified-Since"))
+		}
+
+		w.Header().Set("Cache-Control", "max-age=300")
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer origin.Close()
+
+	server.defaultRoute.origins = newOrigins(origin.URL, nil)
+
+	req, _ := http.NewRequest("GET", "/bucket/file.txt", nil)
+
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789" {
+		t.Error("expected cached body, got", w.Code, w.Body.String())
+	}
+
+	if w.Header().Get("X-Cache") != "HIT-REVALIDATED" {
+		t.Error("expected X-Cache HIT-REVALIDATED, got", w.Header().Get("X-Cache"))
+	}
+
+	entry := server.fileCache.PathEntry("/bucket/file.txt")
+
+	if entry.Headers.Get("Cache-Control") != "max-age=300" || entry.Stale(time.Now()) {
+		t.Error("expected entry to be refreshed, got", entry.Headers)
+	}
+
+	if entry.Headers.Get("Etag") != `"abc"` {
+		t.Error("expected stored headers to be kept")
+	}
+
+	if server.stats.revalidations != 1 {
+		t.Error("expected one revalidation, got", server.stats.revalidations)
+	}
+
+	w = httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+
+	if w.Header().Get("X-Cache") != "HIT" {
+		t.Error("expected fresh entry to hit, got", w.Header().Get("X-Cache"))
+	}
+}
+
+func TestRevalidateChanged(t *testing.T) {
+	server, cleanup := setupTestServer(t, map[string]string{
+		"/bucket/file.txt": "0123456789",
+	})
+	defer cleanup()
+
+	makePathStale(server, "/bucket/file.txt")
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Etag", `"def"`)
+		w.Header().Set("Content-Length", "10")
+		w.Write([]byte("abcdefghij"))
+	}))
+	defer origin.Close()
+
+	server.defaultRoute.origins = newOrigins(origin.URL, nil)
+
+	req, _ := http.NewRequest("GET", "/bucket/file.txt", nil)
+
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+
+	if w.Body.String() != "abcdefghij" || w.Header().Get("X-Cache") != "MISS" {
+		t.Error("expected changed file to be refetched, got", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+
+	if w.Body.String() != "abcdefghij" || w.Header().Get("X-Cache") != "HIT" {
+		t.Error("expected refetched file to be cached, got", w.Body.String())
+	}
+
+	if server.fileCache.PathEntry("/bucket/file.txt").Headers.Get("Etag") != `"def"` {
+		t.Error("expected new headers to be stored")
+	}
+}
+
+func TestServeCacheNotModified(t *testing.T) {
+	server, cleanup := setupTestServer(t, map[string]string{
+		"/bucket/file.txt": "0123456789",
+	})
+	defer cleanup()
+
+	req, _ := http.NewRequest("GET", "/bucket/file.txt", nil)
+	req.Header.Set("If-None-Match", `"abc"`)
+
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Error("expected 304 without a body, got", w.Code, w.Body.String())
+	}
+
+	if w.Header().Get("Etag") != `"abc"` || w.Header().Get("Content-Type") != "" {
+		t.Error("unexpected 304 headers", w.Header())
+	}
+
+	if w.Header().Get("X-Cache") != "HIT" {
+		t.Error("expected X-Cache HIT, got", w.Header().Get("X-Cache"))
+	}
+
+	if server.stats.notModified != 1 || server.stats.bytesSent != 0 {
+		t.Error("expected not modified to be counted without bytes sent")
+	}
+
+	req.Header.Set("If-None-Match", `"old"`)
+
+	w = httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789" {
+		t.Error("expected full body for changed etag, got", w.Code)
+	}
+}
+
+func TestHeadRequests(t *testing.T) {
+	server, cleanup := setupTestServer(t, map[string]string{
+		"/bucket/file.txt": "0123456789",
+	})
+	defer cleanup()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "HEAD" {
+			t.Error("expected only HEAD requests to the origin, got", r.Method)
+		}
+
+		if r.URL.Path == "/bucket/missing.txt" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Length", "20")
+		w.Header().Set("Etag", `"other"`)
+	}))
+	defer origin.Close()
+
+	server.defaultRoute.origins = newOrigins(origin.URL, nil)
+
+	newRequest := func(path string) *http.Request {
+		req, _ := http.NewRequest("HEAD", path, nil)
+		return req
+	}
+
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, newRequest("/bucket/file.txt"))
+
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Error("expected HEAD hit without a body, got", w.Code, w.Body.String())
+	}
+
+	if w.Header().Get("Content-Length") != "10" || w.Header().Get("X-Cache") != "HIT" {
+		t.Error("expected stored headers, got", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, newRequest("/bucket/other.txt"))
+
+	if w.Code != http.StatusOK || w.Header().Get("Etag") != `"other"` {
+		t.Error("expected origin headers for miss, got", w.Code, w.Header())
+	}
+
+	if w.Header().Get("X-Cache") != "MISS" {
+		t.Error("expected X-Cache MISS, got", w.Header().Get("X-Cache"))
+	}
+
+	if server.fileCache.PathEntry("/bucket/other.txt") != nil {
+		t.Error("HEAD miss should not store anything")
+	}
+
+	w = httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, newRequest("/bucket/missing.txt"))
+
+	if w.Code != http.StatusNotFound {
+		t.Error("expected origin status for missing path, got", w.Code)
+	}
+
+	if server.stats.headHits != 1 || server.stats.headMisses != 2 {
+		t.Error("unexpected HEAD stats", server.stats.headHits, server.stats.headMisses)
+	}
+
+	if server.stats.sizeDist[0] != 0 {
+		t.Error("HEAD requests should not count as transfers")
+	}
+}
+
+func TestAdminPurgePaths(t *testing.T) {
+	server, cleanup := setupTestServer(t, map[string]string{
+		"/builds/1/a.zip": "0123456789",
+		"/builds/1/b.zip": "0123456789",
+		"/builds/2/a.zip": "0123456789",
+	})
+	defer cleanup()
+
+	adminRequest := func(path string) string {
+		req, _ := http.NewRequest("POST", path, nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+
+		w := httptest.NewRecorder()
+		server.AdminHandler().ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Error("admin request failed", path, w.Code, w.Body.String())
+		}
+
+		return w.Body.String()
+	}
+
+	body := adminRequest("/admin/purge-paths?prefix=/builds/1/&dry_run=1")
+
+	if body != "Matched:  2\n/builds/1/a.zip\n/builds/1/b.zip\n" {
+		t.Error("unexpected dry run output", body)
+	}
+
+	if server.fileCache.CountPurgedPaths() != 0 {
+		t.Error("dry run should not purge")
+	}
+
+	body = adminRequest("/admin/purge-paths?glob=/builds/*/a.zip")
+
+	if body != "Matched:  2\nPurged:  2\n" {
+		t.Error("unexpected purge output", body)
+	}
+
+	if !server.fileCache.PathNeedsPurge("/builds/2/a.zip") || server.fileCache.PathNeedsPurge("/builds/1/b.zip") {
+		t.Error("expected only matching paths to be purged")
+	}
+
+	body = adminRequest("/admin/delete-paths?regex=b\\.zip$")
+
+	if body != "Matched:  1\nDeleted:  1\n" {
+		t.Error("unexpected delete output", body)
+	}
+
+	if size, _ := server.fileCache.PathMaybeAvailable("/builds/1/b.zip"); size != 0 || server.fileCache.PathEntry("/builds/1/b.zip") != nil {
+		t.Error("expected matching path to be deleted")
+	}
+}
+
+func TestAdminPurgeTag(t *testing.T) {
+	server, cleanup := setupTestServer(t, nil)
+	defer cleanup()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bucket/other.txt" {
+			w.Header().Set("X-Goog-Meta-Surrogate-Key", "upload-1")
+		}
+
+		w.Write([]byte("0123456789"))
+	}))
+	defer origin.Close()
+
+	server.defaultRoute.origins = newOrigins(origin.URL, nil)
+
+	for _, path := range []string{"/bucket/a.txt", "/other/b.txt", "/bucket/other.txt"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		server.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req, _ := http.NewRequest("POST", "/admin/purge-tag?tag=upload-1", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+
+	w := httptest.NewRecorder()
+	server.AdminHandler().ServeHTTP(w, req)
+
+	if w.Body.String() != "Matched:  2\nPurged:  2\n" {
+		t.Error("unexpected purge output", w.Code, w.Body.String())
+	}
+
+	if !server.fileCache.PathNeedsPurge("/other/b.txt") || server.fileCache.PathNeedsPurge("/bucket/other.txt") {
+		t.Error("expected only tagged paths to be purged")
+	}
+}
@@ -0,0 +1,184 @@
+package dullcache
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm = "AWS4-HMAC-SHA256"
+	sigV4Service   = "s3"
+	sigV4DateTime  = "20060102T150405Z"
+	sigV4Date      = "20060102"
+)
+
+// hash of the empty body of the requests dullcache sends
+const sigV4EmptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Signs requests to S3 compatible stores with AWS Signature Version 4 in the
+// Authorization header. Every request is signed
+type sigV4Signer struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	region          string
+}
+
+func newSigV4Signer(accessKeyID, secretAccessKey, sessionToken, region string) *sigV4Signer {
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &sigV4Signer{
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		sessionToken:    sessionToken,
+		region:          region,
+	}
+}
+
+func (signer *sigV4Signer) SignsPath(path string) bool {
+	return true
+}
+
+func (signer *sigV4Signer) SignRequest(req *http.Request) error {
+	signer.signRequestAt(req, time.Now())
+	return nil
+}
+
+// Percent encodes s the way SigV4 canonicalizes URIs and query strings. Slashes
+// are kept when encoding paths
+func sigV4Escape(s string, path bool) string {
+	var buf bytes.Buffer
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+			buf.WriteByte(c)
+		case c == '-', c == '.', c == '_', c == '~':
+			buf.WriteByte(c)
+		case c == '/' && path:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+
+	return buf.String()
+}
+
+// Returns the query of req sorted by name and value, with both escaped
+func sigV4CanonicalQuery(req *http.Request) string {
+	var pairs []string
+
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			pairs = append(pairs, sigV4Escape(name, false)+"="+sigV4Escape(value, false))
+		}
+	}
+
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// Returns the canonical headers and the list of signed header names. The host
+// and every header already set on req are signed
+func sigV4CanonicalHeaders(req *http.Request) (string, string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	headers := map[string]string{"host": host}
+
+	for name, values := range req.Header {
+		trimmed := make([]string, len(values))
+
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s:%s\n", name, headers[name])
+	}
+
+	return buf.String(), strings.Join(names, ";")
+}
+
+func sigV4HMAC(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Returns the Authorization header for req signed at t, once the x-amz headers
+// have been set
+func (signer *sigV4Signer) authorization(req *http.Request, t time.Time) string {
+	canonicalHeaders, signedHeaders := sigV4CanonicalHeaders(req)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		sigV4CanonicalQuery(req),
+		canonicalHeaders,
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	scope := strings.Join([]string{t.Format(sigV4Date), signer.region, sigV4Service, "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		t.Format(sigV4DateTime),
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := sigV4HMAC([]byte("AWS4"+signer.secretAccessKey), t.Format(sigV4Date))
+	key = sigV4HMAC(key, signer.region)
+	key = sigV4HMAC(key, sigV4Service)
+	key = sigV4HMAC(key, "aws4_request")
+
+	signature := hex.EncodeToString(sigV4HMAC(key, stringToSign))
+
+	return fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, signer.accessKeyID, scope, signedHeaders, signature)
+}
+
+// Signs req as if sent at t. The path is escaped the same way it's
+// canonicalized so the origin sees the signed path
+func (signer *sigV4Signer) signRequestAt(req *http.Request, t time.Time) {
+	t = t.UTC()
+
+	req.Header.Del("Authorization")
+	req.URL.RawPath = sigV4Escape(req.URL.Path, true)
+	req.Header.Set("X-Amz-Date", t.Format(sigV4DateTime))
+	req.Header.Set("X-Amz-Content-Sha256", sigV4EmptyHash)
+
+	if signer.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", signer.sessionToken)
+	}
+
+	req.Header.Set("Authorization", signer.authorization(req, t))
+}
@@ -0,0 +1,151 @@
+package dullcache
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Checks if a stale entry can still be served for directive, with the window
+// from the config in seconds unless the backend set one
+func (server *Server) staleServable(entry *CacheEntry, directive string, seconds int64) bool {
+	window := staleWindow(entry.Headers, directive, time.Duration(seconds)*time.Second)
+	return time.Now().Before(entry.ExpiresAt.Add(window))
+}
+
+// Serves a file found in the cache dir that hasn't been checked against the
+// backend. Only its size is known
+func (server *Server) serveUnverified(w http.ResponseWriter, r *http.Request, size int64) error {
+	entry := &CacheEntry{
+		Headers: http.Header{
+			"Content-Length": []string{strconv.FormatInt(size, 10)},
+		},
+	}
+
+	return server.serveCache(w, r, entry, cacheStatusUnverified)
+}
+
+// Refreshes the path requested by r without making the client wait. The path
+// is marked busy during the refresh so only one runs at a time
+func (server *Server) refreshInBackground(r *http.Request) {
+	subPath := server.cacheKey(r)
+
+	if !server.urlVerified(r) || !server.fileCache.MarkPathBusy(subPath) {
+		return
+	}
+
+	// the refresh outlives the request, don't write to its access record
+	r = withRoute(context.Background(), r, server.requestRoute(r))
+
+	go func() {
+		defer server.fileCache.MarkPathFree(subPath)
+
+		err := server.refresh(r)
+
+		if err != nil {
+			log.Print("Failed to refresh: ", subPath, ": ", err)
+		}
+	}()
+}
+
+// Revalidates a stale entry, or checks an unverified file, refetching the file
+// if it has changed. Expects the path to be marked busy
+func (server *Server) refresh(r *http.Request) error {
+	subPath := server.cacheKey(r)
+	entry := server.fileCache.PathEntry(subPath)
+
+	if entry == nil {
+		size, err := server.fileCache.PathMaybeAvailable(subPath)
+
+		if err != nil {
+			return err
+		}
+
+		headers, err := server.headPath(r)
+
+		// the backend no longer has it, stop serving the unverified file
+		if err != nil && !originFailed(err) {
+			fname, _ := server.fileCache.CacheFilePath(subPath)
+			os.Remove(fname)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if headersContentLength(headers) == size {
+			server.fileCache.MarkPathAvailable(subPath, headers)
+			log.Print("Verified in background: ", subPath)
+			return nil
+		}
+
+		entry = &CacheEntry{Headers: http.Header{}}
+	}
+
+	remoteRes, err := server.fetchConditional(r, entry)
+
+	if err != nil {
+		return err
+	}
+
+	switch remoteRes.StatusCode {
+	case http.StatusNotModified:
+		remoteRes.Body.Close()
+		server.markNotModified(subPath, entry, remoteRes)
+		log.Print("Revalidated in background: ", subPath)
+		return nil
+	case http.StatusOK:
+		log.Print("Refetching in background: ", subPath)
+		return server.fillPath(subPath, remoteRes)
+	}
+
+	remoteRes.Body.Close()
+	return &originStatusError{remoteRes.StatusCode}
+}
+
+// Stores a response from the backend without sending it to a client. Requests
+// for the path can stream from the fill while it's written. Expects the path
+// to be marked busy
+func (server *Server) fillPath(subPath string, remoteRes *http.Response) error {
+	defer remoteRes.Body.Close()
+
+	needsPurge := server.fileCache.PathNeedsPurge(subPath)
+	file, err := server.fileCache.PathWriter(subPath)
+
+	if err != nil {
+		return err
+	}
+
+	headers := filterHeaders(remoteRes.Header)
+	fill := server.fileCache.startFill(subPath, file, headers)
+
+	server.stats.incrStores(1)
+	copied, err := io.Copy(fill, remoteRes.Body)
+	server.stats.incrBytesFetched(uint64(copied))
+
+	if err != nil {
+		server.fileCache.endFill(subPath, err)
+		server.fileCache.AbortPathWriter(file)
+		return err
+	}
+
+	err = server.fileCache.CommitPathWriter(subPath, file, remoteRes.ContentLength)
+
+	if err != nil {
+		server.fileCache.endFill(subPath, err)
+		return err
+	}
+
+	server.fileCache.MarkPathAvailable(subPath, headers)
+	server.fileCache.endFill(subPath, nil)
+
+	if needsPurge {
+		server.fileCache.ReleasePathPurge(subPath)
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+package dullcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Waits for a background refresh of path to finish
+func waitPathFree(t *testing.T, server *Server, path string) {
+	deadline := time.Now().Add(5 * time.Second)
+
+	for server.fileCache.PathBusy(path) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for refresh of", path)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	server, cleanup := setupTestServer(t, map[string]string{
+		"/bucket/file.txt": "0123456789",
+	})
+	defer cleanup()
+
+	makePathStale(server, "/bucket/file.txt")
+	server.config.StaleWhileRevalidate = 120
+
+	release := make(chan bool)
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Cache-Control", "max-age=300")
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer origin.Close()
+
+	server.defaultRoute.origins = newOrigins(origin.URL, nil)
+
+	req, _ := http.NewRequest("GET", "/bucket/file.txt", nil)
+
+	// served while the origin is still blocked
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+
+	if w.Body.String() != "0123456789" || w.Header().Get("X-Cache") != "HIT-STALE" {
+		t.Error("expected stale copy to be served, got", w.Body.String(), w.Header().Get("X-Cache"))
+	}
+
+	close(release)
+	waitPathFree(t, server, "/bucket/file.txt")
+
+	if server.fileCache.PathEntry("/bucket/file.txt").Stale(time.Now()) {
+		t.Error("expected background refresh to revalidate entry")
+	}
+
+	w = httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+
+	if w.Header().Get("X-Cache") != "HIT" {
+		t.Error("expected X-Cache HIT after refresh, got", w.Header().Get("X-Cache"))
+	}
+}
+
+func TestStaleWhileRevalidateRefetch(t *testing.T) {
+	server, cleanup := setupTestServer(t, map[string]string{
+		"/bucket/file.txt": "0123456789",
+	})
+	defer cleanup()
+
+	makePathStale(server, "/bucket/file.txt")
+	server.config.StaleWhileRevalidate = 120
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "10")
+		w.Write([]byte("abcdefghij"))
+	}))
+	defer origin.Close()
+
+	server.defaultRoute.origins = newOrigins(origin.URL, nil)
+
+	req, _ := http.NewRequest("GET", "/bucket/file.txt", nil)
+
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+
+	if w.Body.String() != "0123456789" {
+		t.Error("expected stale copy to be served, got", w.Body.String())
+	}
+
+	waitPathFree(t, server, "/bucket/file.txt")
+
+	w = httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+
+	if w.Body.String() != "abcdefghij" || w.Header().Get("X-Cache") != "HIT" {
+		t.Error("expected refetched file, got", w.Body.String(), w.Header().Get("X-Cache"))
+	}
+}
+
+func TestStaleIfError(t *testing.T) {
+	server, cleanup := setupTestServer(t, map[string]string{
+		"/bucket/file.txt": "0123456789",
+	})
+	defer cleanup()
+
+	makePathStale(server, "/bucket/file.txt")
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer origin.Close()
+
+	server.defaultRoute.origins = newOrigins(origin.URL, nil)
+
+	req, _ := http.NewRequest("GET", "/bucket/file.txt", nil)
+
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Error("expected error without stale-if-error, got", w.Code)
+	}
+
+	server.config.StaleIfError = 300
+
+	w = httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+
+	if w.Body.String() != "0123456789" || w.Header().Get("X-Cache") != "HIT-STALE" {
+		t.Error("expected stale copy on 5xx, got", w.Code, w.Body.String())
+	}
+
+	// unreachable backend
+	origin.Close()
+
+	w = httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+
+	if w.Body.String() != "0123456789" || w.Header().Get("X-Cache") != "HIT-STALE" {
+		t.Error("expected stale copy when backend is down, got", w.Code, w.Body.String())
+	}
+
+	if server.stats.staleHits != 2 {
+		t.Error("expected 2 stale hits, got", server.stats.staleHits)
+	}
+}
+
+func TestServeUnverified(t *testing.T) {
+	server, cleanup := setupTestServer(t, nil)
+	defer cleanup()
+
+	file, err := server.fileCache.PathWriter("/bucket/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.Write([]byte("0123456789"))
+
+	if err := server.fileCache.CommitPathWriter("/bucket/file.txt", file, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "HEAD" {
+			t.Error("expected only a HEAD to verify the file, got", r.Method)
+		}
+
+		w.Header().Set("Content-Length", "10")
+	}))
+	defer origin.Close()
+
+	server.defaultRoute.origins = newOrigins(origin.URL, nil)
+	server.config.StaleWhileRevalidate = 60
+
+	req, _ := http.NewRequest("GET", "/bucket/file.txt", nil)
+
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+
+	if w.Body.String() != "0123456789" || w.Header().Get("X-Cache") != "HIT-UNVERIFIED" {
+		t.Error("expected unverified copy to be served, got", w.Body.String(), w.Header().Get("X-Cache"))
+	}
+
+	waitPathFree(t, server, "/bucket/file.txt")
+
+	if server.fileCache.PathEntry("/bucket/file.txt") == nil {
+		t.Error("expected file to be verified in the background")
+	}
+}
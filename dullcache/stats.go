@@ -11,14 +11,30 @@ import (
 )
 
 type serverStats struct {
-	bytesFetched uint64
-	bytesSent    uint64
-	fastHits     uint64
-	checkedHits  uint64
-	passes       uint64
-	stores       uint64
-	activePaths  map[string]int64
-	sizeDist     map[uint64]uint64
+	bytesFetched  uint64
+	bytesSent     uint64
+	fastHits      uint64
+	checkedHits   uint64
+	coalesced     uint64
+	revalidations uint64
+	staleHits     uint64
+	notModified   uint64
+	negativeHits  uint64
+	headHits      uint64
+	headMisses    uint64
+	passes        uint64
+	stores        uint64
+	evictions     uint64
+	bytesEvicted  uint64
+	activePaths   map[string]int64
+	sizeDist      map[uint64]uint64
+
+	// total bytes of the transfers counted in sizeDist
+	sizeDistBytes uint64
+
+	// transfers by the smallest sizeDistsMB bound they are at most, the last
+	// bucket counts transfers over every bound
+	sizeHistogram []uint64
 
 	sync.RWMutex
 }
@@ -27,18 +43,34 @@ var sizeDistsMB = []uint64{0, 1, 10, 20, 30, 50, 100, 200, 500, 750}
 
 func newServerStats() *serverStats {
 	return &serverStats{
-		activePaths: make(map[string]int64),
-		sizeDist:    make(map[uint64]uint64),
+		activePaths:   make(map[string]int64),
+		sizeDist:      make(map[uint64]uint64),
+		sizeHistogram: make([]uint64, len(sizeDistsMB)),
 	}
 }
 
+// Returns the sizeHistogram bucket for a transfer of amount bytes
+func sizeHistogramBucket(amount uint64) int {
+	for i, mb := range sizeDistsMB[1:] {
+		if amount <= mb*1024*1024 {
+			return i
+		}
+	}
+
+	return len(sizeDistsMB) - 1
+}
+
 // amount in bytes
 func (stats *serverStats) incrSizeDist(amount uint64) {
+	stats.Lock()
+	defer stats.Unlock()
+
+	stats.sizeHistogram[sizeHistogramBucket(amount)] += 1
+	stats.sizeDistBytes += amount
+
 	mb := amount / (1024 * 1024)
 	for i := len(sizeDistsMB) - 1; i >= 0; i-- {
 		if mb >= sizeDistsMB[i] {
-			stats.Lock()
-			defer stats.Unlock()
 			stats.sizeDist[sizeDistsMB[i]] += 1
 			return
 		}
@@ -71,6 +103,34 @@ func (stats *serverStats) incrCheckedHits(amount uint64) {
 	atomic.AddUint64(&stats.checkedHits, amount)
 }
 
+func (stats *serverStats) incrCoalesced(amount uint64) {
+	atomic.AddUint64(&stats.coalesced, amount)
+}
+
+func (stats *serverStats) incrRevalidations(amount uint64) {
+	atomic.AddUint64(&stats.revalidations, amount)
+}
+
+func (stats *serverStats) incrStaleHits(amount uint64) {
+	atomic.AddUint64(&stats.staleHits, amount)
+}
+
+func (stats *serverStats) incrNotModified(amount uint64) {
+	atomic.AddUint64(&stats.notModified, amount)
+}
+
+func (stats *serverStats) incrNegativeHits(amount uint64) {
+	atomic.AddUint64(&stats.negativeHits, amount)
+}
+
+func (stats *serverStats) incrHeadHits(amount uint64) {
+	atomic.AddUint64(&stats.headHits, amount)
+}
+
+func (stats *serverStats) incrHeadMisses(amount uint64) {
+	atomic.AddUint64(&stats.headMisses, amount)
+}
+
 func (stats *serverStats) incrPasses(amount uint64) {
 	atomic.AddUint64(&stats.passes, amount)
 }
@@ -79,6 +139,14 @@ func (stats *serverStats) incrStores(amount uint64) {
 	atomic.AddUint64(&stats.stores, amount)
 }
 
+func (stats *serverStats) incrEvictions(amount uint64) {
+	atomic.AddUint64(&stats.evictions, amount)
+}
+
+func (stats *serverStats) incrBytesEvicted(amount uint64) {
+	atomic.AddUint64(&stats.bytesEvicted, amount)
+}
+
 func (stats *serverStats) countActivePaths() int {
 	stats.RLock()
 	defer stats.RUnlock()
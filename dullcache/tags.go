@@ -0,0 +1,74 @@
+package dullcache
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Sets the backend response header that lists the surrogate keys of a path.
+// Keys are separated by spaces or commas
+func (cache *FileCache) SetTagHeader(header string) {
+	cache.availableMutex.Lock()
+	defer cache.availableMutex.Unlock()
+	cache.tagHeader = http.CanonicalHeaderKey(header)
+}
+
+// Returns the surrogate keys in headers. Expects the available mutex to be
+// held
+func (cache *FileCache) headersTags(headers http.Header) []string {
+	if cache.tagHeader == "" {
+		return nil
+	}
+
+	value := strings.Join(headers[cache.tagHeader], " ")
+	return strings.Fields(strings.Replace(value, ",", " ", -1))
+}
+
+// Adds path to the index of every tag. Expects the available mutex to be held
+func (cache *FileCache) indexTags(path string, tags []string) {
+	for _, tag := range tags {
+		paths := cache.tagPaths[tag]
+
+		if paths == nil {
+			paths = make(map[string]bool)
+			cache.tagPaths[tag] = paths
+		}
+
+		paths[path] = true
+	}
+}
+
+// Removes path from the index of every tag. Expects the available mutex to be
+// held
+func (cache *FileCache) unindexTags(path string, tags []string) {
+	for _, tag := range tags {
+		paths := cache.tagPaths[tag]
+		delete(paths, path)
+
+		if len(paths) == 0 {
+			delete(cache.tagPaths, tag)
+		}
+	}
+}
+
+// Returns the available paths tagged with tag, sorted
+func (cache *FileCache) TaggedPaths(tag string) []string {
+	cache.availableMutex.RLock()
+	defer cache.availableMutex.RUnlock()
+
+	paths := make([]string, 0, len(cache.tagPaths[tag]))
+	for path := range cache.tagPaths[tag] {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+	return paths
+}
+
+// Returns the total number of tags with available paths
+func (cache *FileCache) CountTags() int {
+	cache.availableMutex.RLock()
+	defer cache.availableMutex.RUnlock()
+	return len(cache.tagPaths)
+}
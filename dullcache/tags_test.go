@@ -0,0 +1,62 @@
+package dullcache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTaggedPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dullcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	cache := NewFileCache(dir)
+	cache.SetTagHeader("x-goog-meta-surrogate-key")
+
+	tagged := func(keys string) http.Header {
+		return http.Header{"X-Goog-Meta-Surrogate-Key": {keys}}
+	}
+
+	for _, path := range []string{"/a.zip", "/b.zip"} {
+		file, err := cache.PathWriter(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := cache.CommitPathWriter(path, file, -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cache.MarkPathAvailable("/a.zip", tagged("upload-1 game"))
+	cache.MarkPathAvailable("/b.zip", tagged("upload-2,game"))
+
+	if strings.Join(cache.TaggedPaths("game"), " ") != "/a.zip /b.zip" {
+		t.Error("expected both paths tagged game, got", cache.TaggedPaths("game"))
+	}
+
+	// refetched with different keys
+	cache.MarkPathAvailable("/a.zip", tagged("upload-3"))
+
+	if strings.Join(cache.TaggedPaths("game"), " ") != "/b.zip" {
+		t.Error("expected old tags to be removed, got", cache.TaggedPaths("game"))
+	}
+
+	if len(cache.TaggedPaths("upload-1")) != 0 || cache.CountTags() != 3 {
+		t.Error("expected empty tags to be dropped, got", cache.CountTags())
+	}
+
+	if err := cache.DeletePath("/b.zip"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cache.TaggedPaths("game")) != 0 {
+		t.Error("expected deleted path to be removed from tags")
+	}
+}
@@ -1,18 +1,26 @@
 package dullcache
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"strconv"
 	"strings"
 	"time"
-
-	"google.golang.org/cloud/storage"
 )
 
+const signedURLBase = "https://storage.googleapis.com"
+
 type urlSigner struct {
-	privateKey     []byte
+	privateKey     *rsa.PrivateKey
 	googleAccessID string
 	expireAfter    time.Duration
 }
@@ -24,27 +32,113 @@ func NewURLSigner(googleAccessID, privateKeyPath string) (*urlSigner, error) {
 		return nil, err
 	}
 
+	privateKey, err := parsePrivateKey(pemBytes)
+
+	if err != nil {
+		return nil, err
+	}
+
 	return &urlSigner{
-		privateKey:     pemBytes,
+		privateKey:     privateKey,
 		googleAccessID: googleAccessID,
 		expireAfter:    time.Duration(5) * time.Minute,
 	}, nil
 }
 
+// Parses a PEM encoded PKCS8 or PKCS1 RSA key
+func parsePrivateKey(key []byte) (*rsa.PrivateKey, error) {
+	if block, _ := pem.Decode(key); block != nil {
+		key = block.Bytes
+	}
+
+	parsedKey, err := x509.ParsePKCS8PrivateKey(key)
+
+	if err != nil {
+		parsedKey, err = x509.ParsePKCS1PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	privateKey, ok := parsedKey.(*rsa.PrivateKey)
+
+	if !ok {
+		return nil, fmt.Errorf("private key is not an RSA key")
+	}
+
+	return privateKey, nil
+}
+
+// Percent encodes an object name for the path of a URL. Everything but
+// unreserved characters and slashes is escaped
+func escapeObjectName(name string) string {
+	var buf bytes.Buffer
+
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+			buf.WriteByte(c)
+		case c == '-', c == '.', c == '_', c == '~', c == '/':
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+
+	return buf.String()
+}
+
+// The canonical string signed for a V2 signed URL. The object name is signed
+// unescaped, the same as the Google client libraries do
+func signatureString(method, bucket, name string, expires int64) string {
+	return fmt.Sprintf("%s\n\n\n%d\n/%s/%s",
+		method, expires, bucket, name)
+}
+
+func signatureHash(method, bucket, name string, expires int64) []byte {
+	sum := sha256.Sum256([]byte(signatureString(method, bucket, name, expires)))
+	return sum[:]
+}
+
 func (signer *urlSigner) SignURL(method, bucket, name string) (string, error) {
 	return signer.SignURLWithExpire(method, bucket, name,
 		time.Now().Add(signer.expireAfter))
 }
 
 func (signer *urlSigner) SignURLWithExpire(method, bucket, name string, expires time.Time) (string, error) {
-	options := storage.SignedURLOptions{
-		GoogleAccessID: signer.googleAccessID,
-		PrivateKey:     signer.privateKey,
-		Method:         method,
-		Expires:        expires,
+	signedPath, err := signer.SignPathWithExpire(method, bucket, name, expires)
+
+	if err != nil {
+		return "", err
+	}
+
+	return signedURLBase + signedPath, nil
+}
+
+// Returns the signed path and query for an object, to be appended to the base
+// URL of any GCS endpoint
+func (signer *urlSigner) SignPath(method, bucket, name string) (string, error) {
+	return signer.SignPathWithExpire(method, bucket, name,
+		time.Now().Add(signer.expireAfter))
+}
+
+func (signer *urlSigner) SignPathWithExpire(method, bucket, name string, expires time.Time) (string, error) {
+	signature, err := rsa.SignPKCS1v15(rand.Reader, signer.privateKey,
+		crypto.SHA256, signatureHash(method, bucket, name, expires.Unix()))
+
+	if err != nil {
+		return "", err
 	}
 
-	return storage.SignedURL(bucket, name, &options)
+	query := url.Values{}
+	query.Set("GoogleAccessId", signer.googleAccessID)
+	query.Set("Expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("Signature", base64.StdEncoding.EncodeToString(signature))
+
+	return fmt.Sprintf("/%s/%s?%s", bucket, escapeObjectName(name),
+		query.Encode()), nil
 }
 
 func (signer *urlSigner) SplitBucketAndName(path string) (string, string, error) {
@@ -57,47 +151,41 @@ func (signer *urlSigner) SplitBucketAndName(path string) (string, string, error)
 	return "", "", fmt.Errorf("failed to split bucket and name")
 }
 
+// Checks that a URL was signed for GET by the configured key and hasn't
+// expired. The path of the URL is expected to be decoded
 func (signer *urlSigner) VerifyURL(checkURL *url.URL) error {
 	values := checkURL.Query()
 
-	expiresStr := values.Get("Expires")
-	expires, err := strconv.Atoi(expiresStr)
+	if values.Get("GoogleAccessId") != signer.googleAccessID {
+		return fmt.Errorf("wrong access id")
+	}
+
+	expires, err := strconv.ParseInt(values.Get("Expires"), 10, 64)
 	if err != nil {
 		return fmt.Errorf("missing expire")
 	}
 
 	// already expired, skip
-	if int(time.Now().Unix()) > expires {
-		return fmt.Errorf("already expired: ", int(time.Now().Unix())-expires)
+	if time.Now().Unix() > expires {
+		return fmt.Errorf("already expired: %v", time.Now().Unix()-expires)
 	}
 
-	// need to fix the special chars issue before I can enable this
-	/*
-		bucket, name, err := signer.SplitBucketAndName(checkURL.Path)
-		if err != nil {
-			return fmt.Errorf("invalid path")
-		}
-
-		signedURLStr, err := signer.SignURLWithExpire("GET", bucket, name,
-			time.Unix(int64(expires), 0))
-
-		if err != nil {
-			return fmt.Errorf("failed to calculate signature")
-		}
+	signature, err := base64.StdEncoding.DecodeString(values.Get("Signature"))
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("missing signature")
+	}
 
-		signedURL, err := url.Parse(signedURLStr)
-		if err != nil {
-			return fmt.Errorf("failed to calculate signature")
-		}
+	bucket, name, err := signer.SplitBucketAndName(checkURL.Path)
+	if err != nil {
+		return fmt.Errorf("invalid path")
+	}
 
-		expectedSignature := signedURL.Query().Get("Signature")
+	err = rsa.VerifyPKCS1v15(&signer.privateKey.PublicKey, crypto.SHA256,
+		signatureHash("GET", bucket, name, expires), signature)
 
-		if values.Get("Signature") == expectedSignature {
-			log.Print("SIGNATURES MATCH")
-		} else {
-			log.Print("MISMATCH SIGNATURES")
-		}
-	*/
+	if err != nil {
+		return fmt.Errorf("invalid signature")
+	}
 
 	return nil
 }
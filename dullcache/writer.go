@@ -0,0 +1,111 @@
+package dullcache
+
+import (
+	"io"
+	"net/http"
+)
+
+// Wraps a ResponseWriter to track the status and body bytes written to the
+// client when the copy is done by something else, like http.ServeContent
+type countingWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+	err     error
+}
+
+func (w *countingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+
+	return n, err
+}
+
+// Lets io.Copy hand the body to the underlying ResponseWriter so files are
+// still sent with sendfile
+func (w *countingWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	var n int64
+	var err error
+
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(writerOnly{w.ResponseWriter}, r)
+	}
+
+	w.written += n
+
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+
+	return n, err
+}
+
+func (w *countingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Hides every method but Write so io.Copy doesn't call back into ReadFrom
+type writerOnly struct {
+	io.Writer
+}
+
+// Copies a backend response into a cache fill and the client. If the client
+// goes away the fill keeps being written as long as keepFilling allows it
+type fillTeeWriter struct {
+	fill        io.Writer
+	client      io.Writer
+	keepFilling func(written int64) bool
+
+	written       int64
+	clientWritten int64
+	clientErr     error
+}
+
+func (w *fillTeeWriter) Write(p []byte) (int, error) {
+	n, err := w.fill.Write(p)
+	w.written += int64(n)
+
+	if err != nil {
+		return n, err
+	}
+
+	if w.clientErr == nil {
+		var clientN int
+		clientN, w.clientErr = w.client.Write(p)
+		w.clientWritten += int64(clientN)
+
+		if w.clientErr != nil && !w.keepFilling(w.written) {
+			return n, w.clientErr
+		}
+	}
+
+	return n, nil
+}
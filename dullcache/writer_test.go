@@ -0,0 +1,137 @@
+package dullcache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("client went away")
+}
+
+func TestFillTeeWriterKeepsFilling(t *testing.T) {
+	var fill bytes.Buffer
+
+	tee := &fillTeeWriter{
+		fill:   &fill,
+		client: failingWriter{},
+		keepFilling: func(written int64) bool {
+			return true
+		},
+	}
+
+	_, err := bytes.NewBufferString("hello world").WriteTo(tee)
+
+	if err != nil {
+		t.Fatal("expected copy to finish, got", err)
+	}
+
+	if fill.String() != "hello world" {
+		t.Error("expected fill to be complete, got", fill.String())
+	}
+
+	if tee.clientErr == nil {
+		t.Error("expected client error to be recorded")
+	}
+}
+
+func TestFillTeeWriterStopsFilling(t *testing.T) {
+	var fill bytes.Buffer
+
+	tee := &fillTeeWriter{
+		fill:   &fill,
+		client: failingWriter{},
+		keepFilling: func(written int64) bool {
+			return false
+		},
+	}
+
+	_, err := tee.Write([]byte("hello"))
+
+	if err == nil || !strings.Contains(err.Error(), "went away") {
+		t.Error("expected client error, got", err)
+	}
+}
+
+func TestFinishAbandonedFill(t *testing.T) {
+	c := defaultConfig
+	server := &Server{config: &c}
+
+	if server.finishAbandonedFill(100, 10) {
+		t.Error("expected abandoned fills to be disabled by default")
+	}
+
+	server.config.FinishAbandonedFills = true
+	server.config.AbandonedFillMaxBytes = 50
+
+	if !server.finishAbandonedFill(100, 60) {
+		t.Error("expected fill under the limit to continue")
+	}
+
+	if server.finishAbandonedFill(100, 10) {
+		t.Error("expected fill over the limit to stop")
+	}
+
+	if server.finishAbandonedFill(-1, 10) {
+		t.Error("expected fill of unknown size to stop")
+	}
+
+	server.config.AbandonedFillMaxBytes = 0
+
+	if !server.finishAbandonedFill(-1, 10) {
+		t.Error("expected fill to continue without a limit")
+	}
+}
+
+type readFromRecorder struct {
+	*httptest.ResponseRecorder
+	readFrom int
+}
+
+func (w *readFromRecorder) ReadFrom(r io.Reader) (int64, error) {
+	w.readFrom += 1
+	return io.Copy(w.ResponseRecorder, r)
+}
+
+func TestCountingWriterReadFrom(t *testing.T) {
+	recorder := &readFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	counter := &countingWriter{ResponseWriter: recorder}
+
+	// the way http.ServeContent copies bodies
+	n, err := io.CopyN(counter, strings.NewReader("hello world"), 11)
+
+	if err != nil || n != 11 {
+		t.Fatal("expected copy to finish, got", n, err)
+	}
+
+	if recorder.readFrom != 1 {
+		t.Error("expected copy to use ReadFrom of the underlying writer")
+	}
+
+	if counter.written != 11 || counter.status != 200 || recorder.Body.String() != "hello world" {
+		t.Error("unexpected count", counter.written, counter.status, recorder.Body.String())
+	}
+
+	plain := httptest.NewRecorder()
+	counter = &countingWriter{ResponseWriter: plain}
+
+	if _, err := io.CopyN(counter, strings.NewReader("hello"), 5); err != nil || counter.written != 5 {
+		t.Error("expected copy without ReadFrom to be counted, got", counter.written, err)
+	}
+
+	counter.Flush()
+
+	if !plain.Flushed {
+		t.Error("expected Flush to reach the underlying writer")
+	}
+
+	if counter.Unwrap() != plain {
+		t.Error("expected Unwrap to return the underlying writer")
+	}
+}